@@ -2,21 +2,23 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/gidyon/micros/utils/healthcheck"
 
-	"github.com/jinzhu/gorm"
-	"google.golang.org/grpc/grpclog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/gidyon/micros"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gidyon/config"
 
-	"github.com/go-redis/redis"
+	"github.com/gidyon/koviapp-ussd/pkg/ussd"
 )
 
 func main() {
@@ -28,14 +30,105 @@ func main() {
 	service, err := micros.NewService(ctx, cfg, nil)
 	handleError(err)
 
-	ussdAPI := &ussdAPIServer{
-		cache:            service.RedisClient(),
-		sqlDB:            service.GormDB(),
-		logger:           service.Logger(),
-		ministryHotlines: []string{"0732353535", "0729471414"},
+	phoneHashKey := os.Getenv("PHONE_HASH_SECRET")
+	if phoneHashKey == "" {
+		handleError(errors.New("PHONE_HASH_SECRET env is required"))
+	}
+
+	retentionDays, err := getEnvInt("DATA_RETENTION_DAYS", 90)
+	handleError(err)
+
+	isolationDays, err := getEnvInt("ISOLATION_DAYS", 14)
+	handleError(err)
+
+	sessionTTLMinutes, err := getEnvInt("SESSION_TTL_MINUTES", 10)
+	handleError(err)
+
+	maxScreeningsPerDay, err := getEnvInt("MAX_SCREENINGS_PER_DAY", 10)
+	handleError(err)
+
+	// Gateways drop sessions that take more than a few seconds
+	requestTimeoutMillis, err := getEnvInt("REQUEST_TIMEOUT_MS", 3000)
+	handleError(err)
+
+	phoneRateLimit, err := ussd.ParseRateLimit(getEnv("RATE_LIMIT_PHONE", ussd.DefaultPhoneRateLimit))
+	handleError(err)
+
+	networkRateLimit, err := ussd.ParseRateLimit(os.Getenv("RATE_LIMIT_NETWORK"))
+	handleError(err)
+
+	var (
+		sessions    ussd.SessionStore
+		rateLimiter ussd.RateLimiter
+	)
+	switch getEnv("SESSION_STORE", "redis") {
+	case "redis":
+		sessions = ussd.NewRedisSessionStore(service.RedisClient())
+		rateLimiter = ussd.NewRedisRateLimiter(service.RedisClient())
+	case "memory":
+		sessions = ussd.NewMemorySessionStore()
+		rateLimiter = ussd.NewMemoryRateLimiter()
+	default:
+		handleError(errors.Errorf("unknown session store %q", os.Getenv("SESSION_STORE")))
+	}
+
+	opt := &ussd.Options{
+		SQLDB:        service.GormDB(),
+		PhoneHashKey: []byte(phoneHashKey),
+		SessionStore: sessions,
+		SessionTTL:   time.Duration(sessionTTLMinutes) * time.Minute,
+		Logger:       service.Logger(),
+		Consent: ussd.ConsentOptions{
+			Required: os.Getenv("CONSENT_REQUIRED") != "false",
+			Version:  getEnv("CONSENT_VERSION", "v1"),
+		},
+		DataRetention:   time.Duration(retentionDays) * 24 * time.Hour,
+		IsolationPeriod: time.Duration(isolationDays) * 24 * time.Hour,
+		RateLimiter:     rateLimiter,
+		RequestTimeout:  time.Duration(requestTimeoutMillis) * time.Millisecond,
+		RateLimits: ussd.RateLimitOptions{
+			PerPhone:            phoneRateLimit,
+			PerNetwork:          networkRateLimit,
+			MaxScreeningsPerDay: maxScreeningsPerDay,
+		},
+		// Content file is reloaded when it changes
+		ContentFile: os.Getenv("CONTENT_FILE"),
+	}
+
+	// Hotlines file seeds the hotlines table on first start
+	if hotlinesFile := os.Getenv("HOTLINES_FILE"); hotlinesFile != "" {
+		opt.Hotlines, err = ussd.LoadHotlines(hotlinesFile)
+		handleError(err)
+	}
+
+	// Follow-up team phones are comma separated
+	for _, phone := range strings.Split(os.Getenv("FOLLOW_UP_PHONES"), ",") {
+		if phone = strings.TrimSpace(phone); phone != "" {
+			opt.FollowUpPhones = append(opt.FollowUpPhones, phone)
+		}
+	}
+
+	if os.Getenv("AT_API_KEY") != "" {
+		opt.SMSSender = ussd.NewAfricasTalkingSMS(os.Getenv("AT_USERNAME"), os.Getenv("AT_API_KEY"), os.Getenv("AT_SENDER_ID"))
 	}
 
+	ussdAPI, err := ussd.NewHandler(ctx, opt)
+	handleError(err)
+
 	service.AddEndpoint("/callbacks/ussd/screening", ussdAPI)
+	service.AddEndpoint("/metrics", promhttp.Handler())
+
+	// Admin API is only served when a key is configured
+	if adminAPIKey := os.Getenv("ADMIN_API_KEY"); adminAPIKey != "" {
+		adminAPI, err := ussd.NewAdminHandler(&ussd.AdminOptions{
+			SQLDB:        service.GormDB(),
+			APIKey:       adminAPIKey,
+			Logger:       service.Logger(),
+			PhoneHashKey: []byte(phoneHashKey),
+		})
+		handleError(err)
+		service.AddEndpoint("/api/ussd/", adminAPI)
+	}
 
 	// Health check endpoints
 	service.AddEndpoint("/callbacks/ussd/screening/readyq", healthcheck.RegisterProbe(&healthcheck.ProbeOptions{
@@ -56,333 +149,22 @@ func handleError(err error) {
 	}
 }
 
-type ussdPayload struct {
-	SessionID   string `json:"sessionId,omitempty"`
-	PhoneNumber string `json:"phoneNumber,omitempty"`
-	NetworkCode string `json:"networkCode,omitempty"`
-	ServiceCode string `json:"serviceCode,omitempty"`
-	Text        string `json:"text,omitempty"`
-}
-
-type ussdAPIServer struct {
-	cache            *redis.Client
-	sqlDB            *gorm.DB
-	logger           grpclog.LoggerV2
-	ministryHotlines []string
-}
-
-func (api *ussdAPIServer) httpError(w http.ResponseWriter, userID, errMsg string, statusCode int) {
-	api.logger.Errorf("error happened: %s", errMsg)
-	api.deleteUserSession(userID)
-	http.Error(w, "END "+errMsg, statusCode)
-}
-
-func (api *ussdAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "only POST method allowed", http.StatusInternalServerError)
-		return
+func getEnv(key, defaultVal string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
 	}
+	return val
+}
 
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to parse form: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	ussd := &ussdPayload{
-		SessionID:   r.FormValue("sessionId"),
-		PhoneNumber: r.FormValue("phoneNumber"),
-		NetworkCode: r.FormValue("networkCode"),
-		ServiceCode: r.FormValue("serviceCode"),
-		Text:        r.FormValue("text"),
-	}
-
-	var response string
-
-	api.logger.Infof("request text: %s", ussd.Text)
-
-	switch {
-	case ussd.Text == "":
-		// Save user
-		err = api.saveUser(ussd)
-		if err != nil {
-			http.Error(w, "failed to save user", http.StatusInternalServerError)
-			return
-		}
-
-		response = "CON Welcome to KoviTrace. Select language \n"
-		response += "1. English \n"
-		response += "2. Kiswahili"
-
-	case ussd.Text == "1":
-		err = api.setUserLanguage(ussd, eng)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to set user language", http.StatusInternalServerError)
-			return
-		}
-		response += "CON Select service you want to access. \n"
-		response += "1. Self-Screening for COVID-19 \n"
-		response += "2. View local hotlines"
-	case ussd.Text == "2":
-		err = api.setUserLanguage(ussd, swa)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to set user language", http.StatusInternalServerError)
-			return
-		}
-		response += "CON Changua huduma unachotaka kupata. \n"
-		response += "1. Kujichunguza dhidi ya COVID-19 \n"
-		response += "2. Tazama nambari za eneo"
-
-	case ussd.Text == "1*2":
-		response += "CON Type county name"
-	case ussd.Text == "2*2":
-		response += "CON Andika jina la kaunti"
-
-	case strings.HasPrefix(ussd.Text, "1*2*") || strings.HasPrefix(ussd.Text, "2*2*"):
-		hotlines, err := api.getHotlines(ussd.Text)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to get hotlines", http.StatusInternalServerError)
-			return
-		}
-		if len(hotlines) > 5 {
-			hotlines = hotlines[:5]
-		}
-
-		response += "END County hotlines \n"
-
-		for index, hotline := range hotlines {
-			response += fmt.Sprintf("%d. %s \n", index+1, hotline)
-		}
-
-		response += "\nMinistry hotlines \n"
-
-		for index, hotline := range api.ministryHotlines {
-			response += fmt.Sprintf("%d. %s \n", index+1, hotline)
-		}
-
-		if strings.HasPrefix(ussd.Text, "2*2*") {
-			response += "\nEndelea kutumia KoviTrace. Jizuie"
-		} else {
-			response += "\nKeep using KoviTrace. Keep safe"
-		}
-
-	case strings.HasPrefix(ussd.Text, "1*1") && strings.Count(ussd.Text, "*") == 1:
-		response = "CON Welcome to KoviTrace Self screenig. Provide honest response. \n"
-		response += "How old are you? \n"
-		response += "1. 0 - 15 years \n"
-		response += "2. 15 - 25 years \n"
-		response += "3. 25 - 40 years \n"
-		response += "4. 40 - 60 years \n"
-		response += "5. Above 60 years \n"
-	case strings.HasPrefix(ussd.Text, "2*1") && strings.Count(ussd.Text, "*") == 1:
-		response = "CON Karibu kwenye KoviTrace uchunguzi wa kibinafsi. Toa mwitikio wa kweli \n"
-		response += "Una miaka mingapi? \n"
-		response += "1. Miaka 0 - 15 \n"
-		response += "2. Miaka 15 - 25 \n"
-		response += "3. Miaka 25 - 40 \n"
-		response += "4. Miaka 40 - 60 \n"
-		response += "5. Miaka zaidi ya 60 \n"
-
-		// Age Section
-	case len(ussd.Text) == 5 && (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 2:
-		switch {
-		case strings.HasSuffix(ussd.Text, "*1"):
-			err = api.saveUserAge(ussd.SessionID, "0 - 15", 1)
-		case strings.HasSuffix(ussd.Text, "*2"):
-			err = api.saveUserAge(ussd.SessionID, "15 - 25", 1)
-		case strings.HasSuffix(ussd.Text, "*3"):
-			err = api.saveUserAge(ussd.SessionID, "25 - 40", 2)
-		case strings.HasSuffix(ussd.Text, "*4"):
-			err = api.saveUserAge(ussd.SessionID, "40 - 60", 2)
-		case strings.HasSuffix(ussd.Text, "*5"):
-			err = api.saveUserAge(ussd.SessionID, "Above 60", 3)
-		}
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to save user age", http.StatusInternalServerError)
-			return
-		}
-		response, err = api.responseForCases(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to create response for cases", http.StatusInternalServerError)
-			return
-		}
-
-		// Cases section
-	case len(ussd.Text) == 7 && (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 3:
-		switch {
-		case strings.HasSuffix(ussd.Text, "*1"):
-			err = api.saveUserCases(ussd.SessionID, "More than 100", 2)
-		case strings.HasSuffix(ussd.Text, "*2"):
-			err = api.saveUserCases(ussd.SessionID, "Less than 100", 1)
-		case strings.HasSuffix(ussd.Text, "*3"):
-			err = api.saveUserCases(ussd.SessionID, "Not known", 1)
-		}
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to save cases", http.StatusInternalServerError)
-			return
-		}
-
-		response, err = api.responseForContact(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed create response for contact", http.StatusInternalServerError)
-			return
-		}
-
-		// Contact with suspected or confirmed COVID-19 patient
-	case len(ussd.Text) == 9 && (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 4:
-		switch {
-		case strings.HasSuffix(ussd.Text, "*1"):
-			err = api.saveUserContactStatus(ussd.SessionID, "yes", 3)
-		case strings.HasSuffix(ussd.Text, "*2"):
-			err = api.saveUserContactStatus(ussd.SessionID, "no", 1)
-		case strings.HasSuffix(ussd.Text, "*3"):
-			err = api.saveUserContactStatus(ussd.SessionID, "uknown", 1)
-		}
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed to save cases", http.StatusInternalServerError)
-			return
-		}
-
-		response, err = api.responseForHowContactHappened(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed create response for contact", http.StatusInternalServerError)
-			return
-		}
-
-		// How contact happenned
-	case (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 5:
-		last := strings.LastIndex(ussd.Text, "*")
-		if last > 0 {
-			answers := strings.Split(ussd.Text[last:], ",")
-			if len(answers) == 0 {
-				answers = strings.Split(ussd.Text[last:], " ")
-			}
-
-			for _, answer := range answers {
-				switch strings.TrimSpace(answer) {
-				case "1":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "working together", 1)
-				case "2":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "face to face contact within 1 meter", 2)
-				case "3":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "travelling together", 1)
-				case "4":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "living in the same environment", 2)
-				case "5":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "health care associated exposure", 2)
-				case "6":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "other", 1)
-				case "7":
-					err = api.saveHowContactHappenedSelection(ussd.SessionID, "none", 0)
-				}
-
-				if err != nil {
-					api.logger.Errorln(err)
-					api.httpError(w, ussd.SessionID, "failed to save data", http.StatusInternalServerError)
-					return
-				}
-			}
-		}
-
-		response, err = api.responseForSymptoms(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed create response for symptoms", http.StatusInternalServerError)
-			return
-		}
-
-	case (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 6:
-		last := strings.LastIndex(ussd.Text, "*")
-		if last > 0 {
-			answers := strings.Split(ussd.Text[last:], ",")
-			if len(answers) == 0 {
-				answers = strings.Split(ussd.Text[last:], " ")
-			}
-
-			for _, answer := range answers {
-				switch strings.TrimSpace(answer) {
-				case "1":
-					err = api.saveSymptomsSelection(ussd.SessionID, "difficulty in breathing", 1)
-				case "2":
-					err = api.saveSymptomsSelection(ussd.SessionID, "cough", 1)
-				case "3":
-					err = api.saveSymptomsSelection(ussd.SessionID, "fatigue", 1)
-				case "4":
-					err = api.saveSymptomsSelection(ussd.SessionID, "fever", 1)
-				case "5":
-					err = api.saveSymptomsSelection(ussd.SessionID, "none of the above", 0)
-				}
-
-				if err != nil {
-					api.httpError(w, ussd.SessionID, "failed save symptoms selection", http.StatusInternalServerError)
-					return
-				}
-			}
-		}
-
-		response, err = api.responseForIllness(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed create response for illness", http.StatusInternalServerError)
-			return
-		}
-
-	case (strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*")) &&
-		strings.Count(ussd.Text, "*") == 7:
-		last := strings.LastIndex(ussd.Text, "*")
-		if last > 0 {
-			answers := strings.Split(ussd.Text[last:], ",")
-			if len(answers) == 0 {
-				answers = strings.Split(ussd.Text[last:], " ")
-			}
-
-			for _, answer := range answers {
-				switch strings.TrimSpace(answer) {
-				case "1":
-					err = api.saveIllnessSelection(ussd.SessionID, "diabetes", 1)
-				case "2":
-					err = api.saveIllnessSelection(ussd.SessionID, "asthmatic", 2)
-				case "3":
-					err = api.saveIllnessSelection(ussd.SessionID, "cancer", 1)
-				case "4":
-					err = api.saveIllnessSelection(ussd.SessionID, "hyper tension", 2)
-				case "5":
-					err = api.saveIllnessSelection(ussd.SessionID, "tuberclosis", 2)
-				case "6":
-					err = api.saveIllnessSelection(ussd.SessionID, "respiratory illness", 2)
-				case "7":
-					err = api.saveIllnessSelection(ussd.SessionID, "none of the above", 0)
-				}
-
-				if err != nil {
-					api.httpError(w, ussd.SessionID, "failed save illness selection", http.StatusInternalServerError)
-					return
-				}
-			}
-		}
-
-		// Calculate risk
-		response, err = api.riskAnalysis(ussd.SessionID)
-		if err != nil {
-			api.httpError(w, ussd.SessionID, "failed create risk analysis", http.StatusInternalServerError)
-			return
-		}
-
-	default:
-
+func getEnvInt(key string, defaultVal int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal, nil
 	}
+	v, err := strconv.Atoi(val)
 	if err != nil {
-		api.httpError(w, ussd.SessionID, "failed to get hotlines", http.StatusInternalServerError)
-		return
+		return 0, errors.Wrapf(err, "failed to parse %s env", key)
 	}
-
-	// Send response
-	w.Write([]byte(response))
-}
-
-func (api *ussdAPIServer) getHotlines(county string) ([]string, error) {
-	return []string{"0716282395", "07453423"}, nil
+	return v, nil
 }
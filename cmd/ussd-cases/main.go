@@ -0,0 +1,84 @@
+// Command ussd-cases uploads the daily per-county case counts published by the ministry to the admin API. Files are
+// CSV with county, date and cases columns or a JSON array of {"county", "date", "cases"} objects. Dates are in
+// YYYY-MM-DD and cases are cumulative. The admin API key is read from the ADMIN_API_KEY environment variable.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func main() {
+	var (
+		endpoint = flag.String("url", "http://localhost:9090/api/ussd/cases", "URL of the case counts admin API")
+		file     = flag.String("file", "", "CSV or JSON file with the case counts")
+		format   = flag.String("format", "", "File format, csv or json. Guessed from the file extension if empty")
+		insecure = flag.Bool("insecure", false, "Skip verification of the server certificate")
+	)
+	flag.Parse()
+
+	apiKey := os.Getenv("ADMIN_API_KEY")
+	if apiKey == "" {
+		handleError(errors.New("missing ADMIN_API_KEY"))
+	}
+	if *file == "" {
+		handleError(errors.New("missing -file"))
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+	var contentType string
+	switch *format {
+	case "csv":
+		contentType = "text/csv"
+	case "json":
+		contentType = "application/json"
+	default:
+		handleError(errors.Errorf("unknown format %q, use csv or json", *format))
+	}
+
+	f, err := os.Open(*file)
+	handleError(errors.Wrap(err, "failed to open file"))
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPost, *endpoint, f)
+	handleError(errors.Wrap(err, "failed to create request"))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", contentType)
+
+	client := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+		},
+	}
+	res, err := client.Do(req)
+	handleError(errors.Wrap(err, "failed to upload case counts"))
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	handleError(errors.Wrap(err, "failed to read response"))
+
+	if res.StatusCode != http.StatusOK {
+		handleError(errors.Errorf("upload failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(body))))
+	}
+
+	fmt.Println(strings.TrimSpace(string(body)))
+}
+
+func handleError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,252 @@
+// Command ussd-sim plays the role of a USSD gateway against the screening callback so that menus can be tested
+// from a terminal. Inputs are read from stdin or from a script file with one input per line.
+//
+// With -in-process the service runs inside the simulator with in-memory sessions and an in-memory SQLite database.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gidyon/koviapp-ussd/pkg/ussd"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/grpclog"
+)
+
+func main() {
+	var (
+		endpoint    = flag.String("url", "http://localhost:9090/callbacks/ussd/screening", "URL of the USSD callback")
+		phone       = flag.String("phone", "+254700000000", "Phone number of the simulated user")
+		networkCode = flag.String("network", "63902", "Network code sent to the callback")
+		serviceCode = flag.String("service-code", "*384#", "Service code dialed by the user")
+		sessionID   = flag.String("session", "", "Session id, a random one is generated if empty")
+		script      = flag.String("script", "", "File with one input per line, lines starting with # are ignored")
+		insecure    = flag.Bool("insecure", false, "Skip verification of the server certificate")
+		inProcess   = flag.Bool("in-process", false, "Run the service in the simulator instead of calling a server")
+		noConsent   = flag.Bool("no-consent", false, "Skip the consent screen when running in-process")
+	)
+	flag.Parse()
+
+	if *sessionID == "" {
+		*sessionID = fmt.Sprintf("ATUid_sim_%d", time.Now().UnixNano())
+	}
+
+	gw := &gateway{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+			},
+		},
+		url:         *endpoint,
+		sessionID:   *sessionID,
+		phone:       *phone,
+		networkCode: *networkCode,
+		serviceCode: *serviceCode,
+	}
+
+	if *inProcess {
+		handler, err := newInProcessHandler(!*noConsent)
+		handleError(err)
+		gw.handler = handler
+	}
+
+	var (
+		inputs   = os.Stdin
+		scripted = *script != ""
+	)
+	if scripted {
+		f, err := os.Open(*script)
+		handleError(errors.Wrap(err, "failed to open script"))
+		defer f.Close()
+		inputs = f
+	}
+
+	err := run(gw, inputs, os.Stdout, scripted)
+	handleError(err)
+}
+
+func handleError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run dials the service and keeps sending inputs until the service ends the session
+func run(gw *gateway, in io.Reader, out io.Writer, scripted bool) error {
+	var (
+		text    string
+		scanner = bufio.NewScanner(in)
+	)
+
+	fmt.Fprintf(out, "Dialing %s from %s (session %s)\n", gw.serviceCode, gw.phone, gw.sessionID)
+
+	for {
+		response, err := gw.send(text)
+		if err != nil {
+			return err
+		}
+
+		ended := render(out, response)
+		if ended {
+			return nil
+		}
+
+		input, ok := nextInput(scanner, out, scripted)
+		if !ok {
+			if scripted {
+				return errors.New("script ended before the session was ended by the service")
+			}
+			return nil
+		}
+
+		if text == "" {
+			text = input
+		} else {
+			text += "*" + input
+		}
+	}
+}
+
+func nextInput(scanner *bufio.Scanner, out io.Writer, scripted bool) (string, bool) {
+	for {
+		if !scripted {
+			fmt.Fprint(out, "> ")
+		}
+		if !scanner.Scan() {
+			return "", false
+		}
+		input := strings.TrimSpace(scanner.Text())
+		if scripted {
+			if input == "" || strings.HasPrefix(input, "#") {
+				continue
+			}
+			fmt.Fprintf(out, "> %s\n", input)
+		}
+		return input, true
+	}
+}
+
+// render prints a USSD screen and reports whether the session has ended
+func render(out io.Writer, response string) bool {
+	var (
+		ended = true
+		body  = response
+	)
+
+	switch {
+	case strings.HasPrefix(response, "CON "):
+		ended = false
+		body = strings.TrimPrefix(response, "CON ")
+	case strings.HasPrefix(response, "END "):
+		body = strings.TrimPrefix(response, "END ")
+	}
+
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	width := 0
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+		if len(lines[i]) > width {
+			width = len(lines[i])
+		}
+	}
+
+	border := "+" + strings.Repeat("-", width+2) + "+"
+	fmt.Fprintln(out, border)
+	for _, line := range lines {
+		fmt.Fprintf(out, "| %-*s |\n", width, line)
+	}
+	fmt.Fprintln(out, border)
+
+	if ended {
+		fmt.Fprintln(out, "[session ended]")
+	}
+
+	return ended
+}
+
+func newInProcessHandler(consent bool) (http.Handler, error) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open in-memory database")
+	}
+
+	requestLogger := logrus.New()
+	requestLogger.SetOutput(ioutil.Discard)
+
+	return ussd.NewHandler(context.Background(), &ussd.Options{
+		SQLDB:         db,
+		PhoneHashKey:  []byte("ussd-sim"),
+		SessionStore:  ussd.NewMemorySessionStore(),
+		Logger:        grpclog.NewLoggerV2(ioutil.Discard, os.Stderr, os.Stderr),
+		RequestLogger: requestLogger,
+		Hotlines: []*ussd.Hotline{
+			{Category: ussd.HotlineMinistry, Label: "MoH", Number: "0732353535", Priority: 2},
+			{Category: ussd.HotlineMinistry, Label: "MoH", Number: "0729471414", Priority: 1},
+		},
+		Consent: ussd.ConsentOptions{Required: consent, Version: "v1"},
+	})
+}
+
+// gateway sends requests the same way the USSD gateway does. Requests go to handler if it is set.
+type gateway struct {
+	handler     http.Handler
+	client      *http.Client
+	url         string
+	sessionID   string
+	phone       string
+	networkCode string
+	serviceCode string
+}
+
+func (gw *gateway) send(text string) (string, error) {
+	form := url.Values{}
+	form.Set("sessionId", gw.sessionID)
+	form.Set("phoneNumber", gw.phone)
+	form.Set("networkCode", gw.networkCode)
+	form.Set("serviceCode", gw.serviceCode)
+	form.Set("text", text)
+
+	if gw.handler != nil {
+		req := httptest.NewRequest(http.MethodPost, gw.url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		gw.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return "", errors.Errorf("service responded with status %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+		}
+		return rec.Body.String(), nil
+	}
+
+	res, err := gw.client.PostForm(gw.url, form)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to call service")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read response")
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("service responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,142 @@
+package ussd
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/grpclog"
+)
+
+// AdminOptions contains the dependencies and settings of the admin API
+type AdminOptions struct {
+	// SQLDB is the database used by the USSD handler. Required.
+	SQLDB *gorm.DB
+	// APIKey is the bearer token that admin requests must present. Required.
+	APIKey string
+	// Logger defaults to a logger writing to stdout and stderr
+	Logger grpclog.LoggerV2
+	// PhoneHashKey must be the key of the USSD handler. Health workers can't be registered without it.
+	PhoneHashKey []byte
+}
+
+// NewAdminHandler creates the admin API used by health officials and operators. Routes are under /api/ussd/.
+func NewAdminHandler(opt *AdminOptions) (http.Handler, error) {
+	// Validation
+	switch {
+	case opt == nil:
+		return nil, errors.New("nil options")
+	case opt.SQLDB == nil:
+		return nil, errors.New("nil sql db")
+	case opt.APIKey == "":
+		return nil, errors.New("missing admin api key")
+	}
+
+	admin := &adminAPIServer{
+		sqlDB:        opt.SQLDB,
+		apiKey:       opt.APIKey,
+		logger:       opt.Logger,
+		phoneHashKey: opt.PhoneHashKey,
+		mux:          http.NewServeMux(),
+	}
+
+	if admin.logger == nil {
+		admin.logger = grpclog.NewLoggerV2(os.Stdout, os.Stderr, os.Stderr)
+	}
+
+	admin.mux.HandleFunc("/api/ussd/screenings/export", admin.exportScreenings)
+	admin.mux.HandleFunc("/api/ussd/screenings/summary", admin.summarizeScreenings)
+	admin.mux.HandleFunc("/api/ussd/cases", admin.caseCounts)
+	admin.mux.HandleFunc("/api/ussd/facilities", admin.facilities)
+	admin.mux.HandleFunc("/api/ussd/health-workers", admin.healthWorkers)
+
+	return admin, nil
+}
+
+type adminAPIServer struct {
+	sqlDB        *gorm.DB
+	apiKey       string
+	logger       grpclog.LoggerV2
+	phoneHashKey []byte
+	mux          *http.ServeMux
+}
+
+func (admin *adminAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(admin.apiKey)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	admin.mux.ServeHTTP(w, r)
+}
+
+func (admin *adminAPIServer) internalError(w http.ResponseWriter, errMsg string, err error) {
+	admin.logger.Errorf("%s: %v", errMsg, err)
+	http.Error(w, errMsg, http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// dateRange parses the from and to query parameters. Dates are in YYYY-MM-DD and to is inclusive.
+func dateRange(r *http.Request) (from, to time.Time, err error) {
+	from = time.Unix(0, 0)
+	to = time.Now()
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err = time.ParseInLocation("2006-01-02", v, eastAfricaTime)
+		if err != nil {
+			return from, to, errors.Errorf("invalid from date %q", v)
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = time.ParseInLocation("2006-01-02", v, eastAfricaTime)
+		if err != nil {
+			return from, to, errors.Errorf("invalid to date %q", v)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return from, to, nil
+}
+
+// readCSV reads a CSV file with a header row. Rows are keyed by lower case column name.
+func readCSV(r io.Reader, required ...string) ([]map[string]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse csv")
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing csv header")
+	}
+
+	columns := make(map[string]int, len(records[0]))
+	for index, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Errorf("missing csv column %q", name)
+		}
+	}
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(columns))
+		for name, index := range columns {
+			row[name] = strings.TrimSpace(record[index])
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,231 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Article categories
+const (
+	ArticlePrevention  = "prevention"
+	ArticleSymptoms    = "symptoms"
+	ArticleMyths       = "myths"
+	ArticleVaccination = "vaccination"
+)
+
+// articlePageLength is the most characters of an article body shown on one screen
+const articlePageLength = 140
+
+// articleSMS is the input that sends the full article by SMS
+const articleSMS = "1"
+
+// Article is a short health information article shown in the facts and myths menu
+type Article struct {
+	ID       string            `yaml:"id"`
+	Category string            `yaml:"category"`
+	Title    map[string]string `yaml:"title"`
+	Body     map[string]string `yaml:"body"`
+}
+
+// validateArticles checks that the articles can be listed and read
+func validateArticles(articles []*Article) error {
+	if len(articles) >= pageMore {
+		return errors.Errorf("at most %d articles can be listed", pageMore-1)
+	}
+
+	ids := make(map[string]bool, len(articles))
+	for _, article := range articles {
+		switch {
+		case article.ID == "":
+			return errors.New("article has no id")
+		case ids[article.ID]:
+			return errors.Errorf("article %q is listed more than once", article.ID)
+		case article.Title[eng] == "":
+			return errors.Errorf("article %q has no English title", article.ID)
+		case article.Body[eng] == "":
+			return errors.Errorf("article %q has no English body", article.ID)
+		}
+		switch article.Category {
+		case ArticlePrevention, ArticleSymptoms, ArticleMyths, ArticleVaccination:
+		default:
+			return errors.Errorf("article %q has unknown category %q", article.ID, article.Category)
+		}
+		ids[article.ID] = true
+	}
+
+	return nil
+}
+
+// localized returns the text in the language, falling back to English
+func localized(texts map[string]string, lang string) string {
+	if text, ok := texts[lang]; ok {
+		return text
+	}
+	return texts[eng]
+}
+
+// pages splits the article body into screens without breaking words
+func (article *Article) pages(lang string) []string {
+	var (
+		pages = make([]string, 0)
+		page  = ""
+	)
+	for _, word := range strings.Fields(localized(article.Body, lang)) {
+		if page != "" && len(page)+1+len(word) > articlePageLength {
+			pages = append(pages, page)
+			page = ""
+		}
+		if page != "" {
+			page += " "
+		}
+		page += word
+	}
+	if page != "" {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// handleArticles lists the articles in a paginated menu and shows the chosen article one screen at a time
+func (api *ussdAPIServer) handleArticles(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages = ussd.content.Messages
+		articles = ussd.content.Articles
+		list     = &Question{
+			ID:       "article",
+			Text:     map[string]string{lang: messages.text("articles_title", lang)},
+			Options:  make([]*Option, 0, len(articles)),
+			PageSize: 7,
+		}
+		flow    = newInputFlow(ussd, lang, list)
+		article *Article
+		page    int
+	)
+	for _, a := range articles {
+		list.Options = append(list.Options, &Option{Text: map[string]string{lang: localized(a.Title, lang)}, Value: a.ID})
+	}
+
+	response, err := flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		for _, a := range articles {
+			if a.ID == ans.values[0] {
+				article = a
+			}
+		}
+		flow.question = nil
+		page = 0
+		if last {
+			articlesReadTotal.WithLabelValues(article.ID, "ussd").Inc()
+		}
+		return "", nil
+	}, func(input string, last bool) (string, error) {
+		switch input {
+		case strconv.Itoa(pageMore):
+			if page+1 < len(article.pages(lang)) {
+				page++
+			}
+		case pageBack:
+			// Back from the first screen returns to the list
+			if page == 0 {
+				article = nil
+				flow.question = list
+				break
+			}
+			page--
+		case articleSMS:
+			if !last {
+				break
+			}
+			w.stage = "article_sms"
+			err := api.queueSMS(ctx, ussd.PhoneNumber, localized(article.Title, lang)+"\n"+localized(article.Body, lang), nil)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to send article")
+			}
+			articlesReadTotal.WithLabelValues(article.ID, "sms").Inc()
+			return "END " + messages.text("article_sms_sent", lang), nil
+		}
+		return "", nil
+	})
+	if response != "" || err != nil {
+		return response, err
+	}
+
+	if article == nil {
+		return flow.render(), nil
+	}
+
+	w.stage = "article"
+	pages := article.pages(lang)
+	lines := []string{
+		fmt.Sprintf("%s (%d/%d)", localized(article.Title, lang), page+1, len(pages)),
+		pages[page],
+		fmt.Sprintf("%s. %s", articleSMS, messages.text("article_sms", lang)),
+	}
+	if page+1 < len(pages) {
+		lines = append(lines, fmt.Sprintf("%d. %s", pageMore, messages.text("page_more", lang)))
+	}
+	lines = append(lines, fmt.Sprintf("%s. %s", pageBack, messages.text("page_back", lang)))
+
+	return "CON " + strings.Join(lines, "\n"), nil
+}
+
+// DefaultArticles returns the built-in health information articles
+func DefaultArticles() []*Article {
+	return []*Article{
+		{
+			ID:       "protect_yourself",
+			Category: ArticlePrevention,
+			Title:    translations("How to protect yourself", "Jinsi ya kujikinga"),
+			Body: translations(
+				"Wash your hands often with soap and running water for at least 20 seconds or use an alcohol based sanitizer. "+
+					"Wear a mask in public places, keep a distance of 1.5 metres from others and avoid crowded places. "+
+					"Cover your mouth and nose with a tissue or your elbow when you cough or sneeze.",
+				"Osha mikono yako mara kwa mara kwa sabuni na maji yanayotiririka kwa angalau sekunde 20 au tumia kieuzi chenye pombe. "+
+					"Vaa maski katika maeneo ya umma, kaa umbali wa mita 1.5 kutoka kwa wengine na epuka maeneo yenye watu wengi. "+
+					"Funika mdomo na pua kwa kitambaa au kiwiko unapokohoa au kupiga chafya.",
+			),
+		},
+		{
+			ID:       "signs",
+			Category: ArticleSymptoms,
+			Title:    translations("Signs of COVID-19", "Dalili za COVID-19"),
+			Body: translations(
+				"The most common signs are fever, dry cough and tiredness. Some people lose their sense of taste or smell or have a sore throat. "+
+					"Difficulty in breathing, chest pain or loss of speech are serious signs: call the Ministry of Health hotline or go to the nearest health facility immediately. "+
+					"Some people have no signs but can still spread the virus.",
+				"Dalili za kawaida ni homa, kikohozi kikavu na uchovu. Watu wengine hupoteza uwezo wa kuonja au kunusa au huwa na kidonda cha koo. "+
+					"Shida ya kupumua, maumivu ya kifua au kushindwa kuongea ni dalili hatari: piga nambari ya Wizara ya Afya au nenda kwenye kituo cha afya kilicho karibu mara moja. "+
+					"Watu wengine hawana dalili lakini wanaweza kusambaza virusi.",
+			),
+		},
+		{
+			ID:       "hot_weather",
+			Category: ArticleMyths,
+			Title:    translations("Myth: Hot weather kills the virus", "Uongo: Joto huua virusi"),
+			Body: translations(
+				"Fact: COVID-19 spreads in all climates, including hot and sunny areas. "+
+					"Drinking alcohol, hot water or herbal mixtures or taking antibiotics does not prevent or cure COVID-19. "+
+					"Protect yourself by washing hands, wearing a mask and keeping distance.",
+				"Ukweli: COVID-19 huenea katika hali zote za hewa, hata maeneo yenye joto na jua. "+
+					"Kunywa pombe, maji moto au dawa za mitishamba au kutumia viuavijasumu hakuzuii wala kutibu COVID-19. "+
+					"Jikinge kwa kunawa mikono, kuvaa maski na kukaa mbali na wengine.",
+			),
+		},
+		{
+			ID:       "vaccines",
+			Category: ArticleVaccination,
+			Title:    translations("COVID-19 vaccines", "Chanjo za COVID-19"),
+			Body: translations(
+				"Vaccines approved by the Ministry of Health are safe and reduce the risk of severe illness and death. "+
+					"They do not contain the virus that causes COVID-19 and do not change your DNA. "+
+					"Ask at your nearest health facility about getting vaccinated and keep wearing a mask after vaccination.",
+				"Chanjo zilizoidhinishwa na Wizara ya Afya ni salama na hupunguza hatari ya kuugua vibaya na kifo. "+
+					"Hazina virusi vinavyosababisha COVID-19 na hazibadilishi DNA yako. "+
+					"Uliza katika kituo cha afya kilicho karibu kuhusu kupata chanjo na endelea kuvaa maski baada ya kuchanjwa.",
+			),
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package ussd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArticlePages(t *testing.T) {
+	for _, article := range DefaultArticles() {
+		for _, lang := range []string{eng, swa} {
+			pages := article.pages(lang)
+			if strings.Join(pages, " ") != strings.Join(strings.Fields(article.Body[lang]), " ") {
+				t.Errorf("%s in %s: pages don't add up to the body", article.ID, lang)
+			}
+			for index, page := range pages {
+				if len(page) > articlePageLength {
+					t.Errorf("%s in %s: page %d has %d characters", article.ID, lang, index+1, len(page))
+				}
+			}
+		}
+	}
+}
+
+func TestArticleSentBySMS(t *testing.T) {
+	api := newTestAPI(t)
+
+	// Open the second article and ask for it by SMS from its second screen
+	screen := send(t, api, "ATUid_article", "*384#", "1*1*4*2*98*1")
+	if !strings.HasPrefix(screen, "END ") {
+		t.Fatalf("expected session to end after sending the article, got %q", screen)
+	}
+
+	sms := api.sms.(*fakeSMS)
+	if len(sms.messages) != 1 {
+		t.Fatalf("expected 1 SMS, got %d", len(sms.messages))
+	}
+	article := DefaultArticles()[1]
+	if sms.messages[0] != article.Title[eng]+"\n"+article.Body[eng] {
+		t.Errorf("expected full article by SMS, got %q", sms.messages[0])
+	}
+}
+
+func TestValidateArticles(t *testing.T) {
+	articles := DefaultArticles()
+	articles = append(articles, &Article{ID: articles[0].ID, Category: ArticleMyths, Title: articles[0].Title, Body: articles[0].Body})
+	if err := validateArticles(articles); err == nil {
+		t.Error("expected duplicate article to be invalid")
+	}
+
+	articles = []*Article{{ID: "masks", Category: "rumours", Title: translations("Masks", "Maski"), Body: translations("Wear one", "Vaa")}}
+	if err := validateArticles(articles); err == nil {
+		t.Error("expected unknown category to be invalid")
+	}
+}
@@ -0,0 +1,206 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// maxCaseCountsSize is the largest case counts file accepted by the admin API
+const maxCaseCountsSize = 10 << 20
+
+// caseCount is one row of the ministry case counts files
+type caseCount struct {
+	County string `json:"county"`
+	// Date is in YYYY-MM-DD
+	Date string `json:"date"`
+	// Cases is the cumulative number of confirmed cases on the date
+	Cases int `json:"cases"`
+}
+
+// parseCaseCounts reads case counts from a CSV file with county, date and cases columns or from a JSON array
+func parseCaseCounts(r io.Reader, contentType string) ([]*countyCases, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	counts := make([]*caseCount, 0)
+	switch mediaType {
+	case "application/json":
+		err := json.NewDecoder(r).Decode(&counts)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse json")
+		}
+	case "text/csv":
+		records, err := readCSV(r, "county", "date", "cases")
+		if err != nil {
+			return nil, err
+		}
+		for line, record := range records {
+			cases, err := strconv.Atoi(record["cases"])
+			if err != nil {
+				return nil, errors.Errorf("line %d: invalid cases %q", line+2, record["cases"])
+			}
+			counts = append(counts, &caseCount{County: record["county"], Date: record["date"], Cases: cases})
+		}
+	default:
+		return nil, errors.Errorf("unsupported content type %q, use text/csv or application/json", contentType)
+	}
+
+	rows := make([]*countyCases, 0, len(counts))
+	for index, count := range counts {
+		county := strings.TrimSpace(count.County)
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(count.Date))
+		switch {
+		case county == "":
+			return nil, errors.Errorf("row %d: missing county", index+1)
+		case err != nil:
+			return nil, errors.Errorf("row %d: invalid date %q", index+1, count.Date)
+		case count.Cases < 0:
+			return nil, errors.Errorf("row %d: negative cases", index+1)
+		}
+		rows = append(rows, &countyCases{County: county, Date: date, Cases: count.Cases})
+	}
+
+	return rows, nil
+}
+
+// saveCaseCounts saves the case counts, replacing the counts of the same county and date
+func saveCaseCounts(db *gorm.DB, rows []*countyCases) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			saved := &countyCases{}
+			err := tx.Where("county = ? AND date = ?", row.County, row.Date).
+				Assign(countyCases{Cases: row.Cases}).
+				FirstOrCreate(saved, countyCases{County: row.County, Date: row.Date}).Error
+			if err != nil {
+				return errors.Wrapf(err, "failed to save cases of %s on %s", row.County, row.Date.Format("2006-01-02"))
+			}
+		}
+		return nil
+	})
+}
+
+// caseCounts imports case counts with POST and lists the latest count of every county with GET
+func (admin *adminAPIServer) caseCounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		rows, err := parseCaseCounts(http.MaxBytesReader(w, r.Body, maxCaseCountsSize), r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = saveCaseCounts(admin.sqlDB, rows)
+		if err != nil {
+			admin.internalError(w, "failed to save case counts", err)
+			return
+		}
+
+		admin.logger.Infof("imported %d county case counts", len(rows))
+		writeJSON(w, map[string]int{"imported": len(rows)})
+
+	case http.MethodGet:
+		rows, err := latestCountyCases(admin.sqlDB)
+		if err != nil {
+			admin.internalError(w, "failed to get case counts", err)
+			return
+		}
+
+		counts := make([]*caseCount, 0, len(rows))
+		for _, row := range rows {
+			counts = append(counts, &caseCount{County: row.County, Date: row.Date.Format("2006-01-02"), Cases: row.Cases})
+		}
+		writeJSON(w, counts)
+
+	default:
+		http.Error(w, "only GET and POST methods allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// weekBeforeTolerance is how much older than a week before the latest count a count can be and still be used for the
+// new cases of the last 7 days
+const weekBeforeTolerance = 24 * time.Hour
+
+// countyStats returns the latest count of the county and the count a week before it. Latest is nil when the county
+// has no figures and week before is nil when there is no count within weekBeforeTolerance of a week before latest.
+func (api *ussdAPIServer) countyStats(county string) (latest, weekBefore *countyCases, err error) {
+	rows := make([]*countyCases, 0)
+	err = api.sqlDB.Order("date DESC").Find(&rows, "LOWER(county) = ?", strings.ToLower(county)).Error
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get county cases")
+	}
+	if len(rows) == 0 {
+		return nil, nil, nil
+	}
+
+	latest = rows[0]
+	weekAgo := latest.Date.AddDate(0, 0, -7)
+	for _, row := range rows[1:] {
+		if !row.Date.After(weekAgo) {
+			if row.Date.Before(weekAgo.Add(-weekBeforeTolerance)) {
+				break
+			}
+			return latest, row, nil
+		}
+	}
+
+	return latest, nil, nil
+}
+
+// handleCountyStats lets the user pick a county from a paginated menu and shows its official figures
+func (api *ussdAPIServer) handleCountyStats(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages = ussd.content.Messages
+		flow     = newInputFlow(ussd, lang, &Question{
+			ID:       "stats_county",
+			Type:     QuestionCounty,
+			Text:     map[string]string{lang: messages.text("stats_county", lang)},
+			PageSize: 7,
+		})
+		county string
+	)
+
+	flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		// Only the last input chooses the county
+		if last {
+			county = ans.values[0]
+			flow.question = nil
+		}
+		return "", nil
+	}, nil)
+
+	switch {
+	case flow.paged:
+		w.stage = "county_stats_page"
+		return flow.render(), nil
+	case flow.notice != "":
+		w.stage = "county_stats_invalid"
+		return flow.render(), nil
+	case flow.question != nil:
+		return flow.render(), nil
+	}
+
+	latest, weekBefore, err := api.countyStats(county)
+	if err != nil {
+		return "", err
+	}
+	if latest == nil {
+		return "END " + fmt.Sprintf(messages.text("stats_none", lang), county), nil
+	}
+
+	lines := []string{fmt.Sprintf(messages.text("stats_result", lang), county, latest.Cases, latest.Date.Format("02 Jan 2006"))}
+	// New cases are only known when there are figures from a week earlier
+	if weekBefore != nil {
+		lines = append(lines, fmt.Sprintf(messages.text("stats_new", lang), latest.Cases-weekBefore.Cases))
+	}
+
+	return "END " + strings.Join(lines, "\n"), nil
+}
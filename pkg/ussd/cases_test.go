@@ -0,0 +1,133 @@
+package ussd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminPost(admin http.Handler, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestImportCaseCounts(t *testing.T) {
+	api := newTestAPI(t)
+	admin := newTestAdmin(t, api)
+
+	rec := adminPost(admin, "/api/ussd/cases", "text/csv", "date,county,cases\n2020-05-01,Nairobi,50\n2020-05-01,Mombasa,20\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A corrected figure replaces the count of the same day
+	rec = adminPost(admin, "/api/ussd/cases", "application/json",
+		`[{"county": "Nairobi", "date": "2020-05-01", "cases": 60}, {"county": "Nairobi", "date": "2020-05-08", "cases": 150}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int
+	err := api.sqlDB.Model(&countyCases{}).Count(&count).Error
+	if err != nil {
+		t.Fatalf("failed to count case counts: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 case counts, got %d", count)
+	}
+
+	rec = adminGet(admin, "/api/ussd/cases")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	latest := make([]*caseCount, 0)
+	err = json.NewDecoder(rec.Body).Decode(&latest)
+	if err != nil {
+		t.Fatalf("failed to decode case counts: %v", err)
+	}
+	if len(latest) != 2 || latest[1].County != "Nairobi" || latest[1].Cases != 150 || latest[1].Date != "2020-05-08" {
+		t.Errorf("expected latest counts of Mombasa and Nairobi, got %+v", latest)
+	}
+
+	// Nairobi is county 30
+	screen := send(t, api, "ATUid_stats", "*384#", "1*1*3*30")
+	for _, want := range []string{"Total confirmed: 150 as of 08 May 2020", "New in the last 7 days: 90"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("expected county stats to contain %q, got %q", want, screen)
+		}
+	}
+}
+
+func TestCountyStatsNeedFiguresFromAWeekBefore(t *testing.T) {
+	api := newTestAPI(t)
+
+	for _, c := range []struct {
+		name  string
+		rows  []*countyCases
+		input string
+		want  string
+	}{
+		{
+			name: "a day more than a week before",
+			rows: []*countyCases{
+				{County: "Mombasa", Date: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), Cases: 20},
+				{County: "Mombasa", Date: time.Date(2020, 5, 9, 0, 0, 0, 0, time.UTC), Cases: 50},
+			},
+			input: "28",
+			want:  "New in the last 7 days: 30",
+		},
+		{
+			name: "months before",
+			rows: []*countyCases{
+				{County: "Nairobi", Date: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), Cases: 10},
+				{County: "Nairobi", Date: time.Date(2020, 5, 8, 0, 0, 0, 0, time.UTC), Cases: 150},
+			},
+			input: "30",
+		},
+	} {
+		for _, row := range c.rows {
+			err := api.sqlDB.Create(row).Error
+			if err != nil {
+				t.Fatalf("failed to save county cases: %v", err)
+			}
+		}
+
+		screen := send(t, api, "ATUid_"+c.input, "*384#", "1*1*3*"+c.input)
+		switch {
+		case !strings.Contains(screen, "Total confirmed"):
+			t.Errorf("%s: expected county stats, got %q", c.name, screen)
+		case c.want == "" && strings.Contains(screen, "New in the last 7 days"):
+			t.Errorf("%s: expected no new cases, got %q", c.name, screen)
+		case !strings.Contains(screen, c.want):
+			t.Errorf("%s: expected county stats to contain %q, got %q", c.name, c.want, screen)
+		}
+	}
+}
+
+func TestImportCaseCountsRejectsInvalidFiles(t *testing.T) {
+	api := newTestAPI(t)
+	admin := newTestAdmin(t, api)
+
+	for _, c := range []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "missing column", contentType: "text/csv", body: "county,cases\nNairobi,50\n"},
+		{name: "invalid date", contentType: "text/csv", body: "county,date,cases\nNairobi,01/05/2020,50\n"},
+		{name: "negative cases", contentType: "application/json", body: `[{"county": "Nairobi", "date": "2020-05-01", "cases": -1}]`},
+		{name: "unknown format", contentType: "text/plain", body: "Nairobi 50"},
+	} {
+		rec := adminPost(admin, "/api/ussd/cases", c.contentType, c.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", c.name, rec.Code)
+		}
+	}
+}
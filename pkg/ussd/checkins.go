@@ -0,0 +1,209 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// modeKey is the session key set when self-screening is replaced by the daily check-in
+	modeKey     = "mode"
+	modeCheckIn = "check_in"
+	// checkInUrgentScore is the check-in score that needs a call whatever the previous days
+	checkInUrgentScore = 5
+	// checkInWorseBy is the rise in score from the previous check-in that counts as deterioration
+	checkInWorseBy = 2
+)
+
+// checkInQuestions are the questions of the daily check-in
+func checkInQuestions(messages Messages, lang string) []*Question {
+	text := func(id string) map[string]string {
+		return map[string]string{lang: messages.text(id, lang)}
+	}
+	return []*Question{
+		{ID: "fever", Type: QuestionYesNo, Text: text("check_in_fever"), YesScore: 1},
+		{
+			ID:   "breathing",
+			Text: text("check_in_breathing"),
+			Options: []*Option{
+				{Text: text("check_in_breathing_normal"), Value: "normal"},
+				{Text: text("check_in_breathing_harder"), Value: "harder", Score: 2},
+				{Text: text("check_in_breathing_very_hard"), Value: "very_hard", Score: checkInUrgentScore},
+			},
+		},
+		{
+			ID:   "feeling",
+			Text: text("check_in_feeling"),
+			Options: []*Option{
+				{Text: text("check_in_feeling_well"), Value: "well"},
+				{Text: text("check_in_feeling_unwell"), Value: "unwell", Score: 1},
+				{Text: text("check_in_feeling_very_unwell"), Value: "very_unwell", Score: 3},
+			},
+		},
+	}
+}
+
+// underFollowUp reports whether the user is in isolation after a positive test or in quarantine after being
+// reported as a close contact. Contacts take the full screening once after the notification so that it is flagged.
+func (api *ussdAPIServer) underFollowUp(phoneHash string, now time.Time) (bool, error) {
+	isolation, err := api.activeIsolation(phoneHash, now)
+	if err != nil {
+		return false, err
+	}
+	if isolation != nil {
+		return true, nil
+	}
+
+	var exposures int
+	err = api.sqlDB.Model(&exposure{}).
+		Where("contact_hash = ? AND screening_id IS NOT NULL AND created_at > ?", phoneHash, now.Add(-api.isolationPeriod)).
+		Count(&exposures).Error
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count exposures")
+	}
+
+	return exposures > 0, nil
+}
+
+// handleCheckIn asks the daily check-in questions
+func (api *ussdAPIServer) handleCheckIn(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages  = ussd.content.Messages
+		questions = checkInQuestions(messages, lang)
+		flow      = newInputFlow(ussd, lang, questions[0])
+		score     = 0
+	)
+
+	flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		score += ans.score
+		flow.question = nextQuestion(questions, flow.question)
+		return "", nil
+	}, nil)
+
+	if flow.question != nil {
+		w.stage = "check_in_" + flow.question.ID
+		if flow.question == questions[0] && flow.notice == "" {
+			flow.notice = messages.text("check_in_intro", lang)
+		}
+		return flow.render(), nil
+	}
+
+	w.stage = "check_in_done"
+	record, saved, err := api.saveCheckIn(ussd, flow.qc.answers, score)
+	if err != nil {
+		return "", err
+	}
+
+	if !record.Urgent {
+		checkInsTotal.WithLabelValues("stable").Inc()
+		return "END " + messages.text("check_in_stable", lang), nil
+	}
+
+	checkInsTotal.WithLabelValues("urgent").Inc()
+
+	// Call now screen with the first ministry line open now
+	hotline := messages.text("check_in_hotline_fallback", lang)
+	if hotlines := api.hotlines.find(HotlineMinistry, "", lang, time.Now()); len(hotlines) > 0 {
+		hotline = hotlines[0].Number
+	}
+
+	// The follow-up team is only told about users who consented
+	if !saved {
+		return "END " + fmt.Sprintf(messages.text("check_in_urgent_no_consent", lang), hotline), nil
+	}
+	api.notifyFollowUpTeam(ctx, ussd, record)
+	return "END " + fmt.Sprintf(messages.text("check_in_urgent", lang), hotline), nil
+}
+
+// saveCheckIn saves the answers as the check-in of today and detects deterioration from the previous check-ins.
+// The check-in is not saved if the user declined consent.
+func (api *ussdAPIServer) saveCheckIn(ussd *ussdPayload, answers map[string][]string, score int) (*checkIn, bool, error) {
+	var (
+		now       = time.Now()
+		day       = today(now)
+		phoneHash = api.hashPhone(ussd.PhoneNumber)
+	)
+
+	previous := make([]*checkIn, 0)
+	err := api.sqlDB.Order("due DESC").Limit(2).
+		Find(&previous, "phone_hash = ? AND completed_at IS NOT NULL AND due < ?", phoneHash, day).Error
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to get previous check-ins")
+	}
+
+	// The check-in scheduled for today or a new one for users in quarantine
+	record := &checkIn{}
+	err = api.sqlDB.Where("phone_hash = ? AND due >= ? AND due < ?", phoneHash, day, day.AddDate(0, 0, 1)).
+		FirstOrInit(record, checkIn{PhoneHash: phoneHash, Due: day}).Error
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to get check-in")
+	}
+	if record.ID == 0 {
+		isolation, err := api.activeIsolation(phoneHash, now)
+		if err != nil {
+			return nil, false, err
+		}
+		if isolation != nil {
+			record.TestResultID = isolation.ID
+		}
+	}
+
+	record.Fever = answers["fever"][0] == "yes"
+	record.Breathing = answers["breathing"][0]
+	record.Feeling = answers["feeling"][0]
+	record.Score = score
+	record.Urgent = deteriorating(score, previous)
+	record.CompletedAt = &now
+
+	allowed, err := api.healthDataAllowed(ussd.SessionID)
+	if err != nil || !allowed {
+		return record, false, err
+	}
+
+	err = api.sqlDB.Save(record).Error
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to save check-in")
+	}
+
+	return record, true, nil
+}
+
+// deteriorating reports whether the score of today needs a call. Previous check-ins are the latest first.
+func deteriorating(score int, previous []*checkIn) bool {
+	switch {
+	case score >= checkInUrgentScore:
+		return true
+	case len(previous) > 0 && score-previous[0].Score >= checkInWorseBy:
+		return true
+	// Getting worse three days in a row
+	case len(previous) > 1 && score > previous[0].Score && previous[0].Score > previous[1].Score:
+		return true
+	}
+	return false
+}
+
+// notifyFollowUpTeam sends the urgent check-in to the follow-up team so that they call the user. The messages are
+// queued so that the call now screen doesn't wait for the SMS gateway.
+func (api *ussdAPIServer) notifyFollowUpTeam(ctx context.Context, ussd *ussdPayload, record *checkIn) {
+	messages := api.content.current().Messages
+	fever := "no"
+	if record.Fever {
+		fever = "yes"
+	}
+	message := fmt.Sprintf(messages.text("follow_up_alert", eng), ussd.PhoneNumber, record.Score, fever, record.Breathing, record.Feeling)
+
+	for _, phone := range api.followUpPhones {
+		failed := func(err error) {
+			if err != nil {
+				api.logger.Errorf("failed to notify follow-up team of check-in %d: %v", record.ID, err)
+			}
+		}
+		failed(api.queueSMS(ctx, phone, message, failed))
+	}
+	if len(api.followUpPhones) == 0 {
+		api.logger.Warningf("urgent check-in %d but no follow-up team phones are configured", record.ID)
+	}
+}
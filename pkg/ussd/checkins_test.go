@@ -0,0 +1,162 @@
+package ussd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInDuringIsolation(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Self-screening is replaced by the check-in while the user is in isolation
+	acceptConsent(t, api, "ATUid_check_in")
+	for _, c := range []struct {
+		text   string
+		screen string
+	}{
+		{text: "1*1*1", screen: "CON Daily check-in\nDo you have a fever today?\n1. Yes\n2. No"},
+		{text: "1*1*1*3", screen: "CON Choose 1 for Yes or 2 for No"},
+		{text: "1*1*1*3*2", screen: "CON How is your breathing?\n1. Normal"},
+		{text: "1*1*1*3*2*1", screen: "CON How do you feel overall?\n1. Well"},
+		{text: "1*1*1*3*2*1*1", screen: "END Thank you for checking in"},
+	} {
+		screen := send(t, api, "ATUid_check_in", "*384#", c.text)
+		if !strings.HasPrefix(screen, c.screen) {
+			t.Errorf("%s: expected screen to start with %q, got %q", c.text, c.screen, screen)
+		}
+	}
+
+	saved := &checkIn{}
+	err := api.sqlDB.First(saved, "due = ?", today(time.Now())).Error
+	if err != nil {
+		t.Fatalf("failed to get check-in: %v", err)
+	}
+	if saved.TestResultID == 0 || saved.CompletedAt == nil || saved.Urgent || saved.Breathing != "normal" {
+		t.Errorf("unexpected check-in %+v", saved)
+	}
+
+	var screenings int
+	api.sqlDB.Model(&screening{}).Count(&screenings)
+	if screenings != 0 {
+		t.Errorf("expected check-in not to be saved as a screening, got %d screenings", screenings)
+	}
+}
+
+func TestCheckInDeterioration(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		opt.FollowUpPhones = []string{"+254700000100"}
+	})
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Fine yesterday
+	completed := time.Now().AddDate(0, 0, -1)
+	err := api.sqlDB.Create(&checkIn{
+		TestResultID: 1, PhoneHash: api.hashPhone(testPhone), Due: today(completed), CompletedAt: &completed,
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to save check-in: %v", err)
+	}
+
+	// Fever and slightly difficult breathing today
+	var screen string
+	for _, text := range []string{"", "1", "1*1", "1*1*1", "1*1*1*1", "1*1*1*1*2", "1*1*1*1*2*1"} {
+		screen = send(t, api, "ATUid_check_in", "*384#", text)
+	}
+	if !strings.HasPrefix(screen, "END Your symptoms are getting worse. CALL NOW: 0732353535") {
+		t.Errorf("expected call now screen, got %q", screen)
+	}
+
+	sms := api.sms.(*fakeSMS)
+	if len(sms.messages) != 1 || !strings.Contains(sms.messages[0], "urgent check-in from "+testPhone) {
+		t.Errorf("expected follow-up team alert, got %q", sms.messages)
+	}
+}
+
+func TestUrgentCheckInDoesNotWaitForFollowUpTeam(t *testing.T) {
+	sms := &slowSMS{delay: 300 * time.Millisecond}
+	api := newTestAPI(t, func(opt *Options) {
+		opt.SMSSender = sms
+		opt.RequestTimeout = 100 * time.Millisecond
+		opt.FollowUpPhones = []string{"+254700000100", "+254700000101"}
+	})
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Fever and very hard breathing
+	for _, text := range []string{"", "1", "1*1", "1*1*1", "1*1*1*1", "1*1*1*1*3"} {
+		send(t, api, "ATUid_check_in", "*384#", text)
+	}
+	start := time.Now()
+	screen := serve(t, api, "ATUid_check_in", "*384#", "1*1*1*1*3*1")
+	if latency := time.Since(start); latency > 100*time.Millisecond {
+		t.Errorf("expected the call now screen within the request timeout, took %v", latency)
+	}
+	if !strings.HasPrefix(screen, "END Your symptoms are getting worse. CALL NOW") {
+		t.Errorf("expected call now screen, got %q", screen)
+	}
+
+	api.smsQueue.wait()
+	if len(sms.messages) != 2 {
+		t.Errorf("expected both follow-up phones to be alerted, got %d SMS", len(sms.messages))
+	}
+}
+
+func TestCheckInDuringQuarantine(t *testing.T) {
+	api := newTestAPI(t)
+
+	notification := &exposure{TestResultID: 1, ReporterHash: "reporter", ContactHash: api.hashPhone(testPhone)}
+	err := api.sqlDB.Create(notification).Error
+	if err != nil {
+		t.Fatalf("failed to save exposure: %v", err)
+	}
+
+	// The first screening after the notification is the full questionnaire
+	var screen string
+	for _, text := range []string{"", "1", "1*1", "1*1*1"} {
+		screen = send(t, api, "ATUid_first", "*384#", text)
+	}
+	if strings.Contains(screen, "Daily check-in") {
+		t.Errorf("expected full screening first, got %q", screen)
+	}
+
+	err = api.sqlDB.Model(notification).Update("screening_id", 1).Error
+	if err != nil {
+		t.Fatalf("failed to link exposure: %v", err)
+	}
+
+	for _, text := range []string{"", "1", "1*1", "1*1*1"} {
+		screen = send(t, api, "ATUid_next", "*384#", text)
+	}
+	if !strings.HasPrefix(screen, "CON Daily check-in") {
+		t.Errorf("expected check-in during quarantine, got %q", screen)
+	}
+}
+
+func TestDeteriorating(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		score    int
+		previous []int
+		want     bool
+	}{
+		{name: "first mild", score: 1, want: false},
+		{name: "first severe", score: checkInUrgentScore, want: true},
+		{name: "same as yesterday", score: 3, previous: []int{3, 3}, want: false},
+		{name: "better", score: 1, previous: []int{4, 3}, want: false},
+		{name: "jump", score: 3, previous: []int{1}, want: true},
+		{name: "three days worse", score: 2, previous: []int{1, 0}, want: true},
+		{name: "worse after better", score: 2, previous: []int{1, 2}, want: false},
+	} {
+		previous := make([]*checkIn, 0, len(c.previous))
+		for _, score := range c.previous {
+			previous = append(previous, &checkIn{Score: score})
+		}
+		if got := deteriorating(c.score, previous); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	consentAccepted = "accepted"
+	consentDeclined = "declined"
+)
+
+// ConsentOptions configures the consent screen shown after language selection
+type ConsentOptions struct {
+	Required bool
+	Version  string
+}
+
+// consentRecord is an audit log entry of a user's consent decision
+type consentRecord struct {
+	ID        uint   `gorm:"primary_key"`
+	PhoneHash string `gorm:"type:varchar(64);index;not null"`
+	SessionID string `gorm:"type:varchar(50);not null"`
+	Version   string `gorm:"type:varchar(20);not null"`
+	Decision  string `gorm:"type:varchar(10);not null"`
+	CreatedAt time.Time
+}
+
+func (*consentRecord) TableName() string {
+	return "ussd_consents"
+}
+
+func (api *ussdAPIServer) responseForConsent(ussd *ussdPayload, lang string) string {
+	return "CON " + ussd.content.Messages.text("consent", lang)
+}
+
+// handleConsent handles the user answer to the consent screen
+func (api *ussdAPIServer) handleConsent(ctx context.Context, ussd *ussdPayload) (string, error) {
+	lang, err := api.getUserLanguage(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user language")
+	}
+
+	switch ussd.Text[strings.LastIndex(ussd.Text, "*")+1:] {
+	case "1":
+		err = api.saveConsent(ussd, consentAccepted)
+		if err != nil {
+			return "", err
+		}
+		err = api.saveContact(ussd.PhoneNumber)
+		if err != nil {
+			return "", err
+		}
+		return api.responseForSelectService(ussd, 0)
+	case "2":
+		err = api.saveConsent(ussd, consentDeclined)
+		if err != nil {
+			return "", err
+		}
+		return api.responseForSelectService(ussd, 0)
+	case "3":
+		err = api.queueSMS(ctx, ussd.PhoneNumber, fmt.Sprintf(ussd.content.Messages.text("consent_details", lang), api.consent.Version), nil)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to send consent details")
+		}
+		return "END " + ussd.content.Messages.text("consent_sms_sent", lang), nil
+	default:
+		return "END " + ussd.content.Messages.text("invalid_choice", lang), nil
+	}
+}
+
+func (api *ussdAPIServer) saveConsent(ussd *ussdPayload, decision string) error {
+	err := api.sqlDB.Create(&consentRecord{
+		PhoneHash: api.hashPhone(ussd.PhoneNumber),
+		SessionID: ussd.SessionID,
+		Version:   api.consent.Version,
+		Decision:  decision,
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to save consent")
+	}
+
+	values := map[string]string{"consent": decision}
+	if decision == consentAccepted {
+		values["phone"] = ussd.PhoneNumber
+	}
+	return api.sessions.SetAll(ussd.SessionID, values)
+}
+
+// healthDataAllowed reports whether the health data entered in the session may be saved or shared. Users who
+// declined consent can still use the services but nothing they enter is kept.
+func (api *ussdAPIServer) healthDataAllowed(sessionID string) (bool, error) {
+	if !api.consent.Required {
+		return true, nil
+	}
+
+	decision, err := api.sessions.Get(sessionID, "consent")
+	switch {
+	case err == errSessionValueNotFound:
+		return false, nil
+	case err != nil:
+		return false, errors.Wrap(err, "failed to get consent")
+	}
+
+	return decision == consentAccepted, nil
+}
+
+// stripConsent removes the consent answer from the text so that the rest of the menus keep their positions
+func stripConsent(text string) string {
+	parts := strings.SplitN(text, "*", 3)
+	if len(parts) < 3 {
+		return text
+	}
+	return parts[0] + "*" + parts[2]
+}
@@ -0,0 +1,311 @@
+package ussd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// builtinContentVersion is the version of the content compiled into the service
+const builtinContentVersion = "builtin"
+
+// contentKey is the session key holding the content version the session started with
+const contentKey = "content"
+
+// Content is what users see: the questionnaire, the screen texts, the hotlines, the locations and the articles. Sessions keep the content
+// version they started with until they end.
+type Content struct {
+	// Version must change whenever the content changes
+	Version       string         `yaml:"version"`
+	Questionnaire *Questionnaire `yaml:"questionnaire"`
+	// Messages override the default screen texts
+	Messages Messages `yaml:"messages"`
+	// Hotlines replace the hotlines table once per content version when set. Hotlines are not pinned to sessions
+	// since numbers must be current.
+	Hotlines []*Hotline `yaml:"hotlines"`
+	// Locations are the counties listed in location menus. Defaults to DefaultLocations.
+	Locations []*County `yaml:"locations"`
+	// Articles are listed in the facts and myths menu. Defaults to DefaultArticles.
+	Articles []*Article `yaml:"articles"`
+}
+
+// Validate checks that the content can be served
+func (content *Content) Validate() error {
+	if content.Version == "" {
+		return errors.New("missing content version")
+	}
+
+	err := content.Questionnaire.Validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid questionnaire")
+	}
+
+	err = content.Messages.Validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid messages")
+	}
+
+	err = validateLocations(content.Locations)
+	if err != nil {
+		return errors.Wrap(err, "invalid locations")
+	}
+
+	err = validateArticles(content.Articles)
+	if err != nil {
+		return errors.Wrap(err, "invalid articles")
+	}
+
+	for _, hotline := range content.Hotlines {
+		err = hotline.Validate()
+		if err != nil {
+			return errors.Wrap(err, "invalid hotlines")
+		}
+	}
+
+	return nil
+}
+
+// LoadContent reads and validates a content file. Missing parts are filled with the built-in content.
+func LoadContent(file string) (*Content, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read content file")
+	}
+	return parseContent(data)
+}
+
+func parseContent(data []byte) (*Content, error) {
+	content := &Content{}
+	err := yaml.UnmarshalStrict(data, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse content file")
+	}
+
+	if content.Questionnaire == nil {
+		content.Questionnaire = DefaultQuestionnaire()
+	}
+	content.Messages = content.Messages.merge(DefaultMessages())
+	if len(content.Locations) == 0 {
+		content.Locations = DefaultLocations()
+	}
+	if len(content.Articles) == 0 {
+		content.Articles = DefaultArticles()
+	}
+
+	err = content.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// contentStore holds the active content and the versions that sessions in progress may still be using
+type contentStore struct {
+	mu       sync.RWMutex
+	active   *Content
+	versions map[string]*Content
+	// retired is when each replaced version stopped being active
+	retired map[string]time.Time
+	// fileHash is the hash of the content file last read
+	fileHash string
+}
+
+func newContentStore(content *Content) *contentStore {
+	store := &contentStore{
+		versions: make(map[string]*Content),
+		retired:  make(map[string]time.Time),
+	}
+	store.swap(content, 0)
+	return store
+}
+
+func (store *contentStore) current() *Content {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.active
+}
+
+// fileChanged records the hash of the content file and reports whether it differs from the last one
+func (store *contentStore) fileChanged(hash string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	changed := store.fileHash != hash
+	store.fileHash = hash
+	return changed
+}
+
+// version returns the content with the version or the active content if the version is no longer kept
+func (store *contentStore) version(version string) *Content {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if content, ok := store.versions[version]; ok {
+		return content
+	}
+	return store.active
+}
+
+// swap makes the content active. Replaced versions are kept for as long as a session can last.
+func (store *contentStore) swap(content *Content, keep time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	if store.active != nil {
+		store.retired[store.active.Version] = now
+	}
+	for version, retiredAt := range store.retired {
+		if now.Sub(retiredAt) > keep {
+			delete(store.versions, version)
+			delete(store.retired, version)
+		}
+	}
+
+	store.active = content
+	store.versions[content.Version] = content
+	delete(store.retired, content.Version)
+
+	contentVersionInfo.Reset()
+	contentVersionInfo.WithLabelValues(content.Version).Set(1)
+}
+
+// sessionContent returns the content the session is pinned to. New sessions get the active content.
+func (api *ussdAPIServer) sessionContent(ussd *ussdPayload) (*Content, error) {
+	if ussd.Text == "" {
+		return api.content.current(), nil
+	}
+
+	version, err := api.sessions.Get(ussd.SessionID, contentKey)
+	switch {
+	case err == errSessionValueNotFound:
+		return api.content.current(), nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to get session content version")
+	}
+
+	return api.content.version(version), nil
+}
+
+// reloadContent swaps the active content if the content file has changed and the new content is valid
+func (api *ussdAPIServer) reloadContent() error {
+	data, err := ioutil.ReadFile(api.contentFile)
+	if err != nil {
+		contentReloadsTotal.WithLabelValues("error").Inc()
+		return errors.Wrap(err, "failed to read content file")
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	// Remember the file even if it is invalid so that the error is reported once per change
+	if api.content != nil && !api.content.fileChanged(hash) {
+		return nil
+	}
+
+	content, err := parseContent(data)
+	if err != nil {
+		contentReloadsTotal.WithLabelValues("invalid").Inc()
+		return err
+	}
+
+	if api.content != nil && api.content.current().Version == content.Version {
+		contentReloadsTotal.WithLabelValues("invalid").Inc()
+		return errors.Errorf("content changed without changing version %q", content.Version)
+	}
+
+	if len(content.Hotlines) > 0 {
+		err = api.applyContentHotlines(content)
+		if err != nil {
+			contentReloadsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+		err = api.reloadHotlines()
+		if err != nil {
+			contentReloadsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+	}
+
+	if api.content == nil {
+		api.content = newContentStore(content)
+		api.content.fileChanged(hash)
+	} else {
+		api.content.swap(content, api.sessionTTL)
+	}
+	contentReloadsTotal.WithLabelValues("success").Inc()
+	api.logger.Infof("serving content version %s with questionnaire version %s", content.Version, content.Questionnaire.Version)
+
+	return nil
+}
+
+// runContentReload checks the content file for changes periodically
+func (api *ussdAPIServer) runContentReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := api.reloadContent()
+		if err != nil {
+			api.logger.Errorf("failed to reload content: %v", err)
+		}
+	}
+}
+
+// contentHotlines records the content versions whose hotlines were applied to the hotlines table
+type contentHotlines struct {
+	Version   string `gorm:"primary_key;type:varchar(20)"`
+	AppliedAt time.Time
+}
+
+func (*contentHotlines) TableName() string {
+	return "ussd_content_hotlines"
+}
+
+// applyContentHotlines replaces all rows of the hotlines table with the hotlines of the content. It is done once per
+// content version so that restarts don't overwrite hotlines changed in the database since. The version row is
+// created in the same transaction so that only one replica applies it.
+func (api *ussdAPIServer) applyContentHotlines(content *Content) error {
+	err := api.sqlDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&contentHotlines{Version: content.Version, AppliedAt: time.Now()}).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to claim content hotlines")
+		}
+		err = tx.Delete(&Hotline{}).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to delete hotlines")
+		}
+		for _, hotline := range content.Hotlines {
+			h := *hotline
+			h.ID = 0
+			err = tx.Create(&h).Error
+			if err != nil {
+				return errors.Wrap(err, "failed to save hotline")
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		api.logger.Infof("applied hotlines of content version %s", content.Version)
+		return nil
+	}
+
+	// The claim fails when another replica or an earlier start applied the version
+	var applied int
+	countErr := api.sqlDB.Model(&contentHotlines{}).Where("version = ?", content.Version).Count(&applied).Error
+	if countErr == nil && applied > 0 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package ussd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeContent(t *testing.T, file, version, services string) {
+	t.Helper()
+
+	content := "version: " + version + "\n" +
+		"messages:\n" +
+		"  services:\n" +
+		"    en: " + services + "\n"
+
+	err := ioutil.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to write content: %v", err)
+	}
+}
+
+func TestContentReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "content.yml")
+	writeContent(t, file, "v1", "Menu one")
+
+	api := newTestAPI(t, func(opt *Options) {
+		opt.ContentFile = file
+	})
+
+	// Session started before the change
+	send(t, api, "ATUid_old", "*384#", "")
+	send(t, api, "ATUid_old", "*384#", "1")
+
+	writeContent(t, file, "v2", "Menu two")
+	err := api.reloadContent()
+	if err != nil {
+		t.Fatalf("failed to reload content: %v", err)
+	}
+	if version := api.content.current().Version; version != "v2" {
+		t.Fatalf("expected active content v2, got %s", version)
+	}
+
+	if screen := send(t, api, "ATUid_old", "*384#", "1*1"); !strings.HasPrefix(screen, "CON Menu one\n1. ") {
+		t.Errorf("expected session in progress to keep content v1, got %q", screen)
+	}
+
+	send(t, api, "ATUid_new", "*384#", "")
+	send(t, api, "ATUid_new", "*384#", "1")
+	if screen := send(t, api, "ATUid_new", "*384#", "1*1"); !strings.HasPrefix(screen, "CON Menu two\n1. ") {
+		t.Errorf("expected new session to get content v2, got %q", screen)
+	}
+}
+
+func TestContentReloadRejectsInvalidContent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "content.yml")
+	writeContent(t, file, "v1", "Menu one")
+
+	api := newTestAPI(t, func(opt *Options) {
+		opt.ContentFile = file
+	})
+
+	cases := map[string]string{
+		"same version": "version: v1\n",
+		"no version":   "messages: {}\n",
+		"placeholders": "version: v2\nmessages:\n  risk_result:\n    en: You are at risk\n",
+		"unknown key":  "version: v2\nquestionaire: {}\n",
+		"no questions": "version: v2\nquestionnaire:\n  questions: []\n",
+	}
+	for name, content := range cases {
+		err := ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = api.reloadContent()
+		if err == nil {
+			t.Errorf("%s: expected content to be rejected", name)
+		}
+		if version := api.content.current().Version; version != "v1" {
+			t.Errorf("%s: expected content v1 to stay active, got %s", name, version)
+		}
+	}
+}
+
+func TestContentHotlinesAppliedOncePerVersion(t *testing.T) {
+	api := newTestAPI(t)
+
+	hotlineNumbers := func() []string {
+		hotlines := make([]*Hotline, 0)
+		err := api.sqlDB.Order("id").Find(&hotlines).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers := make([]string, 0, len(hotlines))
+		for _, hotline := range hotlines {
+			numbers = append(numbers, hotline.Number)
+		}
+		return numbers
+	}
+
+	v1 := &Content{Version: "v1", Hotlines: []*Hotline{{Category: HotlineMinistry, Label: "MoH", Number: "0700000111"}}}
+	err := api.applyContentHotlines(v1)
+	if err != nil {
+		t.Fatalf("failed to apply hotlines: %v", err)
+	}
+	if numbers := hotlineNumbers(); len(numbers) != 1 || numbers[0] != "0700000111" {
+		t.Fatalf("expected the content hotline, got %v", numbers)
+	}
+
+	// Changed in the database, then another replica or a restart loads the same version
+	err = api.sqlDB.Model(&Hotline{}).Where("number = ?", "0700000111").Update("number", "0700000222").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.applyContentHotlines(v1)
+	if err != nil {
+		t.Fatalf("expected an applied version to be skipped, got %v", err)
+	}
+	if numbers := hotlineNumbers(); len(numbers) != 1 || numbers[0] != "0700000222" {
+		t.Errorf("expected the database change to be kept, got %v", numbers)
+	}
+
+	v2 := &Content{Version: "v2", Hotlines: []*Hotline{{Category: HotlineMinistry, Label: "MoH", Number: "0700000333"}}}
+	err = api.applyContentHotlines(v2)
+	if err != nil {
+		t.Fatalf("failed to apply hotlines: %v", err)
+	}
+	if numbers := hotlineNumbers(); len(numbers) != 1 || numbers[0] != "0700000333" {
+		t.Errorf("expected the hotlines of the new version, got %v", numbers)
+	}
+}
+
+func TestLoadContent(t *testing.T) {
+	content, err := LoadContent(filepath.Join("..", "..", "configs", "content.yml"))
+	if err != nil {
+		t.Fatalf("failed to load content: %v", err)
+	}
+	if !strings.HasPrefix(content.Messages.text("services", swa), "Changua huduma") {
+		t.Errorf("expected built-in messages to be kept, got %q", content.Messages.text("services", swa))
+	}
+}
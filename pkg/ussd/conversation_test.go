@@ -0,0 +1,322 @@
+package ussd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"google.golang.org/grpc/grpclog"
+)
+
+var update = flag.Bool("update", false, "rewrite golden conversation files with the current screens")
+
+const (
+	testPhone       = "+254700000001"
+	testNetworkCode = "63902"
+	inputMarker     = ">>> "
+)
+
+// A conversation golden file is a list of exchanges. Each exchange is a line starting with ">>> " followed by the
+// user input and then the screen returned by the service. The first input is the service code that was dialed.
+type exchange struct {
+	input  string
+	screen string
+}
+
+func readConversation(t *testing.T, file string) []exchange {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read conversation: %v", err)
+	}
+
+	content := string(data)
+	if !strings.HasPrefix(content, inputMarker) {
+		t.Fatalf("conversation %s must start with %q", file, inputMarker)
+	}
+
+	exchanges := make([]exchange, 0)
+	for _, block := range strings.Split(content[len(inputMarker):], "\n"+inputMarker) {
+		parts := strings.SplitN(block, "\n", 2)
+		ex := exchange{input: parts[0]}
+		if len(parts) == 2 {
+			ex.screen = strings.TrimSuffix(parts[1], "\n")
+		}
+		exchanges = append(exchanges, ex)
+	}
+
+	return exchanges
+}
+
+func writeConversation(t *testing.T, file string, exchanges []exchange) {
+	t.Helper()
+
+	var sb strings.Builder
+	for _, ex := range exchanges {
+		sb.WriteString(inputMarker + ex.input + "\n")
+		sb.WriteString(ex.screen + "\n")
+	}
+
+	err := ioutil.WriteFile(file, []byte(sb.String()), 0644)
+	if err != nil {
+		t.Fatalf("failed to write conversation: %v", err)
+	}
+}
+
+type fakeSMS struct {
+	mu       sync.Mutex
+	messages []string
+	// err fails every message when set
+	err error
+}
+
+func (sms *fakeSMS) SendSMS(ctx context.Context, phone, message string) error {
+	sms.mu.Lock()
+	defer sms.mu.Unlock()
+	if sms.err != nil {
+		return sms.err
+	}
+	sms.messages = append(sms.messages, message)
+	return nil
+}
+
+// testHotlines has lines of every category. Hours are not set so that screens don't depend on the time of day.
+var testHotlines = []*Hotline{
+	{Category: HotlineMinistry, Label: "MoH", Number: "0732353535", Priority: 2},
+	{Category: HotlineMinistry, Label: "MoH", Number: "0729471414", Priority: 1},
+	{Category: HotlineCounty, County: "Nairobi", Label: "Nairobi Health", Number: "0716282395"},
+	{Category: HotlineCounty, County: "Mombasa", Label: "Mombasa Health", Number: "0745342300", Languages: "sw"},
+	{Category: HotlineMentalHealth, Label: "Mental health", Number: "1199"},
+	{Category: HotlineGBV, Label: "GBV", Number: "1195", Languages: "en"},
+}
+
+func newTestAPI(t *testing.T, configure ...func(opt *Options)) *ussdAPIServer {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	requestLogger := logrus.New()
+	requestLogger.SetOutput(ioutil.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hotlines := make([]*Hotline, 0, len(testHotlines))
+	for _, hotline := range testHotlines {
+		h := *hotline
+		hotlines = append(hotlines, &h)
+	}
+
+	opt := &Options{
+		SQLDB:         db,
+		PhoneHashKey:  []byte("test-secret"),
+		SessionStore:  NewMemorySessionStore(),
+		Logger:        grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard),
+		RequestLogger: requestLogger,
+		SMSSender:     &fakeSMS{},
+		Hotlines:      hotlines,
+		Consent:       ConsentOptions{Required: true, Version: "v1"},
+	}
+	for _, f := range configure {
+		f(opt)
+	}
+
+	handler, err := NewHandler(ctx, opt)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	return handler.(*ussdAPIServer)
+}
+
+// replay sends the inputs the same way the gateway does and returns the screens shown to the user
+func replay(t *testing.T, api *ussdAPIServer, sessionID string, exchanges []exchange) []exchange {
+	t.Helper()
+
+	var (
+		text   string
+		result = make([]exchange, 0, len(exchanges))
+	)
+
+	for i, ex := range exchanges {
+		switch {
+		case i == 0:
+		case i == 1:
+			text = ex.input
+		default:
+			text += "*" + ex.input
+		}
+
+		screen := send(t, api, sessionID, exchanges[0].input, text)
+		result = append(result, exchange{input: ex.input, screen: screen})
+	}
+
+	return result
+}
+
+// send makes a single gateway request with the cumulative text and returns the screen once the messages of the
+// request are sent
+func send(t *testing.T, api *ussdAPIServer, sessionID, serviceCode, text string) string {
+	t.Helper()
+	screen := serve(t, api, sessionID, serviceCode, text)
+	api.smsQueue.wait()
+	return screen
+}
+
+// serve makes a single gateway request with the cumulative text and returns the screen
+func serve(t *testing.T, api *ussdAPIServer, sessionID, serviceCode, text string) string {
+	t.Helper()
+
+	form := url.Values{}
+	form.Set("sessionId", sessionID)
+	form.Set("phoneNumber", testPhone)
+	form.Set("networkCode", testNetworkCode)
+	form.Set("serviceCode", serviceCode)
+	form.Set("text", text)
+
+	req := httptest.NewRequest(http.MethodPost, "/callbacks/ussd/screening", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+
+	api.ServeHTTP(rec, req)
+
+	screen := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		screen = fmt.Sprintf("[status %d] %s", rec.Code, screen)
+	}
+
+	return strings.TrimSuffix(screen, "\n")
+}
+
+// acceptConsent starts the session in English and accepts consent so that the session may save health data
+func acceptConsent(t *testing.T, api *ussdAPIServer, sessionID string) {
+	t.Helper()
+	for _, text := range []string{"", "1", "1*1"} {
+		send(t, api, sessionID, "*384#", text)
+	}
+}
+
+func diffScreens(want, got string) string {
+	var (
+		sb        strings.Builder
+		wantLines = strings.Split(want, "\n")
+		gotLines  = strings.Split(got, "\n")
+	)
+
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w == g {
+			sb.WriteString("  " + w + "\n")
+			continue
+		}
+		if i < len(wantLines) {
+			sb.WriteString(fmt.Sprintf("- %q\n", w))
+		}
+		if i < len(gotLines) {
+			sb.WriteString(fmt.Sprintf("+ %q\n", g))
+		}
+	}
+
+	return sb.String()
+}
+
+// conversationFacilities are listed by the facility locator in conversations. Their long names show how facilities
+// are paged.
+var conversationFacilities = []*Facility{
+	{
+		County:    "Kisumu",
+		SubCounty: "Kisumu Central",
+		Name:      "Jaramogi Oginga Odinga Teaching and Referral Hospital Isolation and Treatment Centre",
+		Services:  "testing,isolation,treatment",
+		Phone:     "0711000010",
+	},
+	{
+		County:    "Kisumu",
+		SubCounty: "Kisumu Central",
+		Name:      "Kisumu County Hospital Outpatient Department COVID-19 Testing and Vaccination Site",
+		Services:  "testing,vaccination",
+		OpensAt:   "08:00",
+		ClosesAt:  "17:00",
+		Phone:     "0711000011",
+	},
+	{
+		County:    "Kisumu",
+		SubCounty: "Kisumu Central",
+		Name:      "Kisumu Central Sub-County Mobile Testing, Vaccination, Isolation and Home Based Care Team",
+		Services:  "testing,vaccination,isolation,treatment",
+		OpensAt:   "08:00",
+		ClosesAt:  "17:00",
+		Phone:     "0711000012",
+	},
+	{County: "Kisumu", SubCounty: "Kisumu Central", Name: "Lumumba Health Centre", Services: "testing", Phone: "0711000013"},
+}
+
+func TestConversations(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conversations", "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no conversation golden files found")
+	}
+
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".golden")
+
+		t.Run(name, func(t *testing.T) {
+			want := readConversation(t, file)
+			api := newTestAPI(t)
+			err := saveFacilities(api.sqlDB, conversationFacilities)
+			if err != nil {
+				t.Fatalf("failed to save facilities: %v", err)
+			}
+			got := replay(t, api, "ATUid_"+name, want)
+
+			if *update {
+				writeConversation(t, file, got)
+				return
+			}
+
+			for i := range want {
+				if want[i].screen != got[i].screen {
+					t.Errorf("screen after input %d (%q) changed:\n%s", i, want[i].input, diffScreens(want[i].screen, got[i].screen))
+				}
+			}
+		})
+	}
+}
+
+func TestMenusFitScreen(t *testing.T) {
+	for _, name := range []string{"en_services_paging", "sw_services_paging", "en_facilities_long_names"} {
+		exchanges := readConversation(t, filepath.Join("testdata", "conversations", name+".golden"))
+		for _, ex := range exchanges {
+			screen := strings.TrimPrefix(ex.screen, "CON ")
+			if len(screen) > maxScreenLength {
+				t.Errorf("%s: screen after input %q has %d characters:\n%s", name, ex.input, len(screen), screen)
+			}
+		}
+	}
+}
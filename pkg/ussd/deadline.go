@@ -0,0 +1,185 @@
+package ussd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// sqlContextKey is the gorm setting holding the context of the request
+const sqlContextKey = "deadline:context"
+
+type requestTimingsKey struct{}
+
+// requestTimings adds up the time a request spends waiting on redis and the database
+type requestTimings struct {
+	mu         sync.Mutex
+	redis      time.Duration
+	redisCalls int
+	sql        time.Duration
+	sqlCalls   int
+}
+
+// requestTimingsFrom returns the timings of the request or nil if the context doesn't belong to a request
+func requestTimingsFrom(ctx context.Context) *requestTimings {
+	timings, _ := ctx.Value(requestTimingsKey{}).(*requestTimings)
+	return timings
+}
+
+func (timings *requestTimings) addRedis(latency time.Duration) {
+	if timings == nil {
+		return
+	}
+	timings.mu.Lock()
+	timings.redis += latency
+	timings.redisCalls++
+	timings.mu.Unlock()
+}
+
+func (timings *requestTimings) addSQL(latency time.Duration) {
+	if timings == nil {
+		return
+	}
+	timings.mu.Lock()
+	timings.sql += latency
+	timings.sqlCalls++
+	timings.mu.Unlock()
+}
+
+func (timings *requestTimings) fields() logrus.Fields {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	return logrus.Fields{
+		"redis_ms":    timings.redis.Seconds() * 1000,
+		"redis_calls": timings.redisCalls,
+		"sql_ms":      timings.sql.Seconds() * 1000,
+		"sql_calls":   timings.sqlCalls,
+	}
+}
+
+// withContext returns a copy of the server whose session, rate limit and database calls use the context
+func (api *ussdAPIServer) withContext(ctx context.Context) *ussdAPIServer {
+	scoped := *api
+	scoped.sessions = api.sessions.WithContext(ctx)
+	scoped.rateLimiter = api.rateLimiter.WithContext(ctx)
+	scoped.sqlDB = api.sqlDB.Set(sqlContextKey, ctx)
+	return &scoped
+}
+
+// registerSQLDeadline fails gorm operations of requests that are past their deadline. gorm v1 doesn't pass the
+// context to the driver so an operation that already started runs to completion, and Count and Row can't be
+// skipped.
+func registerSQLDeadline(db *gorm.DB) {
+	check := func(scope *gorm.Scope) {
+		ctx, ok := scope.Get(sqlContextKey)
+		if !ok {
+			return
+		}
+		if err := ctx.(context.Context).Err(); err != nil {
+			scope.Err(errors.Wrap(err, "request deadline exceeded"))
+			scope.SkipLeft()
+		}
+	}
+
+	db.Callback().Create().Before("gorm:begin_transaction").Register("deadline:before_create", check)
+	db.Callback().Update().Before("gorm:begin_transaction").Register("deadline:before_update", check)
+	db.Callback().Delete().Before("gorm:begin_transaction").Register("deadline:before_delete", check)
+	db.Callback().Query().Before("gorm:query").Register("deadline:before_query", check)
+}
+
+// responseBuffer holds the response of a request until it is known to be within the deadline
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), status: http.StatusOK}
+}
+
+func (buffer *responseBuffer) Header() http.Header {
+	return buffer.header
+}
+
+func (buffer *responseBuffer) WriteHeader(statusCode int) {
+	buffer.status = statusCode
+}
+
+func (buffer *responseBuffer) Write(data []byte) (int, error) {
+	return buffer.body.Write(data)
+}
+
+func (buffer *responseBuffer) writeTo(w http.ResponseWriter) {
+	for key, values := range buffer.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(buffer.status)
+	w.Write(buffer.body.Bytes())
+}
+
+// serveWithDeadline answers with the busy screen if the request takes longer than the request timeout. Gateways
+// drop sessions that take more than a few seconds so a late response would never reach the user. The request
+// keeps running until its calls return with the context error and is logged then.
+func (api *ussdAPIServer) serveWithDeadline(w http.ResponseWriter, r *http.Request, rl *requestLog) {
+	ctx, cancel := context.WithTimeout(r.Context(), api.requestTimeout)
+	ctx = context.WithValue(ctx, requestTimingsKey{}, rl.timings)
+
+	buffer := newResponseBuffer()
+	rl.ResponseWriter = buffer
+	// The language is read before serving since the handler modifies the text
+	lang := textLanguage(r.FormValue("text"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				rl.err = errors.Errorf("panic: %v", p)
+				http.Error(rl, "END internal error", http.StatusInternalServerError)
+			}
+		}()
+		api.withContext(ctx).serveUSSD(rl, r.WithContext(ctx))
+	}()
+
+	select {
+	case <-done:
+		// Calls that failed because of the deadline also get the busy screen
+		if ctx.Err() == context.DeadlineExceeded && rl.status >= http.StatusInternalServerError {
+			rl.timedOut = true
+			api.writeBusy(w, lang)
+		} else {
+			buffer.writeTo(w)
+		}
+		cancel()
+		api.logRequest(rl)
+
+	case <-ctx.Done():
+		rl.timedOut = ctx.Err() == context.DeadlineExceeded
+		api.writeBusy(w, lang)
+		go func() {
+			<-done
+			cancel()
+			api.logRequest(rl)
+		}()
+	}
+}
+
+func (api *ussdAPIServer) writeBusy(w http.ResponseWriter, lang string) {
+	w.Write([]byte("END " + api.content.current().Messages.text("service_busy", lang)))
+}
+
+// textLanguage is the language chosen at the first input of the text
+func textLanguage(text string) string {
+	if strings.HasPrefix(text, "2") {
+		return swa
+	}
+	return eng
+}
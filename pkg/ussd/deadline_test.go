@@ -0,0 +1,192 @@
+package ussd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// slowSessionStore delays every write to the session
+type slowSessionStore struct {
+	SessionStore
+	delay time.Duration
+}
+
+func (store *slowSessionStore) Set(sessionID, key, value string) error {
+	time.Sleep(store.delay)
+	return store.SessionStore.Set(sessionID, key, value)
+}
+
+func (store *slowSessionStore) WithContext(ctx context.Context) SessionStore {
+	return store
+}
+
+// entryHook passes request log entries to a channel
+type entryHook chan *logrus.Entry
+
+func (hook entryHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook entryHook) Fire(entry *logrus.Entry) error {
+	hook <- entry
+	return nil
+}
+
+func withRequestLogHook(hook entryHook) func(opt *Options) {
+	return func(opt *Options) {
+		opt.RequestLogger.AddHook(hook)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	entries := make(entryHook, 10)
+	api := newTestAPI(t, func(opt *Options) {
+		opt.SessionStore = &slowSessionStore{SessionStore: NewMemorySessionStore(), delay: 200 * time.Millisecond}
+		opt.RequestTimeout = 20 * time.Millisecond
+	}, withRequestLogHook(entries))
+
+	start := time.Now()
+	screen := send(t, api, "timeout", "*384#", "2")
+	if latency := time.Since(start); latency > 150*time.Millisecond {
+		t.Errorf("expected the busy screen before the session call returns, took %v", latency)
+	}
+	want := "END " + DefaultMessages().text("service_busy", swa)
+	if screen != want {
+		t.Errorf("expected %q, got %q", want, screen)
+	}
+
+	// The request is logged once the handler returns
+	select {
+	case entry := <-entries:
+		if entry.Data["outcome"] != "timeout" {
+			t.Errorf("expected timeout outcome, got %v", entry.Data["outcome"])
+		}
+		if entry.Data["stage"] != "language" {
+			t.Errorf("expected language stage, got %v", entry.Data["stage"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request was not logged")
+	}
+}
+
+func TestRequestTimings(t *testing.T) {
+	entries := make(entryHook, 10)
+	api := newTestAPI(t, func(opt *Options) {
+		opt.Consent = ConsentOptions{}
+	}, withRequestLogHook(entries))
+
+	screen := send(t, api, "timings", "*384#", "")
+	if !strings.HasPrefix(screen, "CON") {
+		t.Fatalf("unexpected screen %q", screen)
+	}
+
+	entry := <-entries
+	if entry.Data["outcome"] != "continue" {
+		t.Errorf("expected continue outcome, got %v", entry.Data["outcome"])
+	}
+	// Saving the user queries and creates the contact
+	if calls, _ := entry.Data["sql_calls"].(int); calls == 0 {
+		t.Errorf("expected sql calls to be counted, got %v", entry.Data["sql_calls"])
+	}
+	if _, ok := entry.Data["sql_ms"].(float64); !ok {
+		t.Errorf("expected sql latency, got %v", entry.Data["sql_ms"])
+	}
+}
+
+func TestSQLDeadline(t *testing.T) {
+	api := newTestAPI(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := api.withContext(ctx).sqlDB.First(&screening{}).Error
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("expected query to fail with %v, got %v", context.Canceled, err)
+	}
+
+	err = api.withContext(ctx).sqlDB.Create(&screening{SessionID: "cancelled"}).Error
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("expected create to fail with %v, got %v", context.Canceled, err)
+	}
+
+	var count int
+	err = api.sqlDB.Model(&screening{}).Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no screenings to be saved, got %d", count)
+	}
+}
+
+// contextSMS records the context error of every message
+type contextSMS struct {
+	errs []error
+}
+
+func (sms *contextSMS) SendSMS(ctx context.Context, phone, message string) error {
+	sms.errs = append(sms.errs, ctx.Err())
+	return ctx.Err()
+}
+
+func TestSMSDetachedFromRequestDeadline(t *testing.T) {
+	sms := &contextSMS{}
+	api := newTestAPI(t, func(opt *Options) {
+		opt.SMSSender = sms
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := api.withContext(ctx).queueSMS(ctx, testPhone, "alert", nil)
+	if err != nil {
+		t.Fatalf("failed to queue message: %v", err)
+	}
+	api.smsQueue.wait()
+
+	if len(sms.errs) != 1 || sms.errs[0] != nil {
+		t.Errorf("expected one message with a live context, got %v", sms.errs)
+	}
+}
+
+// slowSMS takes longer than the request timeout to send a message
+type slowSMS struct {
+	fakeSMS
+	delay time.Duration
+}
+
+func (sms *slowSMS) SendSMS(ctx context.Context, phone, message string) error {
+	time.Sleep(sms.delay)
+	return sms.fakeSMS.SendSMS(ctx, phone, message)
+}
+
+func TestSlowSMSGatewayDoesNotDelayScreen(t *testing.T) {
+	sms := &slowSMS{delay: 300 * time.Millisecond}
+	api := newTestAPI(t, func(opt *Options) {
+		opt.SMSSender = sms
+		opt.RequestTimeout = 100 * time.Millisecond
+	})
+
+	send(t, api, "ATUid_slow_sms", "*384#", "")
+	send(t, api, "ATUid_slow_sms", "*384#", "1")
+
+	start := time.Now()
+	screen := serve(t, api, "ATUid_slow_sms", "*384#", "1*3")
+	if latency := time.Since(start); latency > 100*time.Millisecond {
+		t.Errorf("expected the screen within the request timeout, took %v", latency)
+	}
+	if want := "END " + DefaultMessages().text("consent_sms_sent", eng); screen != want {
+		t.Errorf("expected %q, got %q", want, screen)
+	}
+
+	api.smsQueue.wait()
+	if len(sms.messages) != 1 {
+		t.Errorf("expected the details to be sent after the reply, got %d SMS", len(sms.messages))
+	}
+}
@@ -0,0 +1,106 @@
+package ussd
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// screeningSummary is the number of screenings and average score per questionnaire version and risk band
+type screeningSummary struct {
+	QuestionnaireVersion string  `json:"questionnaireVersion"`
+	RiskBand             string  `json:"riskBand"`
+	Screenings           int     `json:"screenings"`
+	AverageScore         float64 `json:"averageScore"`
+}
+
+// exportScreenings writes screening answers as CSV with one row per answer. Phone hashes are not exported.
+// Query parameters: from, to (YYYY-MM-DD), version and excessive=include.
+func (admin *adminAPIServer) exportScreenings(w http.ResponseWriter, r *http.Request) {
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := admin.sqlDB.Table("ussd_screenings s").
+		Select("s.id, s.created_at, s.questionnaire_version, s.language, s.risk_score, s.risk_band, a.question_id, a.value").
+		Joins("JOIN ussd_screening_answers a ON a.screening_id = s.id").
+		Where("s.created_at >= ? AND s.created_at < ?", from, to).
+		Order("s.id, a.id")
+	if version := r.URL.Query().Get("version"); version != "" {
+		db = db.Where("s.questionnaire_version = ?", version)
+	}
+	if !includeExcessive(r) {
+		db = db.Where("s.excessive = ?", false)
+	}
+
+	rows, err := db.Rows()
+	if err != nil {
+		admin.internalError(w, "failed to export screenings", err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="screenings.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"screening_id", "created_at", "questionnaire_version", "language", "risk_score", "risk_band", "question_id", "value",
+	})
+
+	for rows.Next() {
+		var (
+			id                                   uint
+			createdAt                            time.Time
+			version, lang, band, question, value string
+			score                                int
+		)
+		err = rows.Scan(&id, &createdAt, &version, &lang, &score, &band, &question, &value)
+		if err != nil {
+			// Headers are already sent
+			admin.logger.Errorf("failed to read screening: %v", err)
+			break
+		}
+		out.Write([]string{
+			strconv.Itoa(int(id)), createdAt.Format(time.RFC3339), version, lang, strconv.Itoa(score), band, question, value,
+		})
+	}
+
+	out.Flush()
+}
+
+// summarizeScreenings returns screening counts split by questionnaire version and risk band.
+// Query parameters: from and to (YYYY-MM-DD) and excessive=include.
+func (admin *adminAPIServer) summarizeScreenings(w http.ResponseWriter, r *http.Request) {
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := admin.sqlDB.Model(&screening{})
+	if !includeExcessive(r) {
+		db = db.Where("excessive = ?", false)
+	}
+
+	summary := make([]*screeningSummary, 0)
+	err = db.Select("questionnaire_version, risk_band, COUNT(*) AS screenings, AVG(risk_score) AS average_score").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("questionnaire_version, risk_band").
+		Order("questionnaire_version, risk_band").
+		Scan(&summary).Error
+	if err != nil {
+		admin.internalError(w, "failed to summarize screenings", err)
+		return
+	}
+
+	writeJSON(w, summary)
+}
+
+// includeExcessive reports whether screenings flagged as excessive are requested
+func includeExcessive(r *http.Request) bool {
+	return r.URL.Query().Get("excessive") == "include"
+}
@@ -0,0 +1,89 @@
+package ussd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newTestAdmin(t *testing.T, api *ussdAPIServer) http.Handler {
+	t.Helper()
+
+	admin, err := NewAdminHandler(&AdminOptions{SQLDB: api.sqlDB, APIKey: "test-key", Logger: api.logger, PhoneHashKey: api.phoneHashKey})
+	if err != nil {
+		t.Fatalf("failed to create admin handler: %v", err)
+	}
+	return admin
+}
+
+func adminGet(admin http.Handler, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer test-key")
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestScreeningsSplitByQuestionnaireVersion(t *testing.T) {
+	api := newTestAPI(t)
+	admin := newTestAdmin(t, api)
+	version := DefaultQuestionnaire().Version
+
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_screening.golden"))
+	replay(t, api, "ATUid_current", exchanges)
+
+	// A screening of an older questionnaire
+	err := api.sqlDB.Create(&screening{SessionID: "ATUid_v0", QuestionnaireVersion: "v0", RiskScore: 2, RiskBand: riskLow}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := adminGet(admin, "/api/ussd/screenings/summary")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	summary := make([]*screeningSummary, 0)
+	err = json.Unmarshal(rec.Body.Bytes(), &summary)
+	if err != nil {
+		t.Fatalf("failed to decode summary: %v", err)
+	}
+	if len(summary) != 2 {
+		t.Fatalf("expected 2 summary rows, got %d", len(summary))
+	}
+	if summary[0].QuestionnaireVersion != "v0" || summary[1].QuestionnaireVersion != version || summary[1].RiskBand != riskHigh {
+		t.Errorf("unexpected summary %+v %+v", summary[0], summary[1])
+	}
+
+	rec = adminGet(admin, "/api/ussd/screenings/export?version="+version)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	if len(records) != 11 {
+		t.Errorf("expected header and 10 answers, got %d rows", len(records))
+	}
+	for _, record := range records[1:] {
+		if record[2] != version {
+			t.Errorf("expected only questionnaire %s, got %q", version, record[2])
+		}
+	}
+}
+
+func TestAdminRequiresAPIKey(t *testing.T) {
+	admin := newTestAdmin(t, newTestAPI(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/ussd/screenings/summary", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", rec.Code)
+	}
+}
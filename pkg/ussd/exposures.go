@@ -0,0 +1,243 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxContactsPerReport is the most contacts that can be entered in one session
+	maxContactsPerReport = 10
+	// maxContactsPerDay is the most contacts a user can notify in 24 hours
+	maxContactsPerDay = 20
+	// Inputs of the add another contact screen
+	exposureAddContact = "1"
+	exposureSend       = "2"
+)
+
+// exposure is an exposure notification sent to a close contact of a confirmed case. Only phone hashes are stored so
+// that contacts can't be identified from the table.
+type exposure struct {
+	ID           uint   `gorm:"primary_key"`
+	TestResultID uint   `gorm:"index;not null"`
+	ReporterHash string `gorm:"type:varchar(64);index;not null"`
+	ContactHash  string `gorm:"type:varchar(64);index;not null"`
+	// ScreeningID is the first screening of the contact after the notification
+	ScreeningID *uint
+	CreatedAt   time.Time
+}
+
+func (*exposure) TableName() string {
+	return "ussd_exposures"
+}
+
+// exposureQuestions are asked in the notify my contacts menu
+func exposureQuestions(messages Messages, lang string) (consent, phone, more *Question) {
+	consent = &Question{
+		ID:   "exposure_consent",
+		Text: map[string]string{lang: messages.text("exposure_consent", lang)},
+		Options: []*Option{
+			{Text: map[string]string{lang: messages.text("exposure_continue", lang)}, Value: "continue"},
+			{Text: map[string]string{lang: messages.text("exposure_cancel", lang)}, Value: "cancel"},
+		},
+	}
+	phone = &Question{
+		ID:   "exposure_phone",
+		Type: QuestionPhone,
+		Text: map[string]string{lang: messages.text("exposure_phone", lang)},
+	}
+	more = &Question{
+		ID:   "exposure_more",
+		Text: map[string]string{lang: messages.text("exposure_more", lang)},
+		Options: []*Option{
+			{Text: map[string]string{lang: messages.text("exposure_add", lang)}, Value: exposureAddContact},
+			{Text: map[string]string{lang: messages.text("exposure_send", lang)}, Value: exposureSend},
+		},
+	}
+	return consent, phone, more
+}
+
+// handleExposures lets users who reported a positive test enter the numbers of their close contacts and sends
+// the contacts an anonymous exposure SMS
+func (api *ussdAPIServer) handleExposures(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	messages := ussd.content.Messages
+
+	// Only confirmed cases can notify contacts
+	reporterHash := api.hashPhone(ussd.PhoneNumber)
+	isolation, err := api.activeIsolation(reporterHash, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if isolation == nil {
+		w.stage = "exposure_not_eligible"
+		return "END " + messages.text("exposure_not_eligible", lang), nil
+	}
+
+	// Contacts are saved and notified only with the consent of the reporter
+	allowed, err := api.healthDataAllowed(ussd.SessionID)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		w.stage = "exposure_no_consent"
+		return "END " + messages.text("exposure_no_consent", lang), nil
+	}
+
+	var (
+		consent, phone, more = exposureQuestions(messages, lang)
+		flow                 = newInputFlow(ussd, lang, consent)
+		contacts             = make([]string, 0, maxContactsPerReport)
+	)
+
+	response, err := flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		switch flow.question {
+		case consent:
+			if ans.values[0] == "cancel" {
+				w.stage = "exposure_cancelled"
+				return "END " + messages.text("exposure_cancelled", lang), nil
+			}
+			flow.question = phone
+		case phone:
+			if normalizePhone(ussd.PhoneNumber) == ans.values[0] {
+				if last {
+					flow.notice = messages.text("exposure_own_number", lang)
+				}
+				break
+			}
+			if !containsString(contacts, ans.values[0]) {
+				contacts = append(contacts, ans.values[0])
+			}
+			flow.question = more
+		case more:
+			if ans.values[0] == exposureAddContact {
+				if len(contacts) >= maxContactsPerReport {
+					if last {
+						flow.notice = fmt.Sprintf(messages.text("exposure_report_limit", lang), maxContactsPerReport)
+					}
+					break
+				}
+				flow.question = phone
+				break
+			}
+			if !last {
+				break
+			}
+			w.stage = "exposure_send"
+			return api.sendExposures(ctx, ussd, isolation, contacts, lang)
+		}
+		return "", nil
+	}, nil)
+	if response != "" || err != nil {
+		return response, err
+	}
+
+	w.stage = flow.question.ID
+	return flow.render(), nil
+}
+
+// sendExposures notifies the contacts that have not been notified during the isolation period. The reply is the same
+// whether a contact was notified now or earlier so that it can't be used to learn about other cases.
+func (api *ussdAPIServer) sendExposures(
+	ctx context.Context, ussd *ussdPayload, isolation *testResult, contacts []string, lang string,
+) (string, error) {
+	var (
+		messages     = ussd.content.Messages
+		reporterHash = api.hashPhone(ussd.PhoneNumber)
+		now          = time.Now()
+		queued       int
+	)
+
+	// Daily limit of the reporter
+	var notified int
+	err := api.sqlDB.Model(&exposure{}).
+		Where("reporter_hash = ? AND created_at > ?", reporterHash, now.Add(-24*time.Hour)).
+		Count(&notified).Error
+	if err != nil {
+		return "", errors.Wrap(err, "failed to count exposures")
+	}
+	if notified+len(contacts) > maxContactsPerDay {
+		exposuresTotal.WithLabelValues("limited").Add(float64(len(contacts)))
+		api.logger.Warningf("exposure notifications of %s limited after %d contacts", reporterHash, notified)
+		return "END " + fmt.Sprintf(messages.text("exposure_daily_limit", lang), maxContactsPerDay), nil
+	}
+
+	for _, contact := range contacts {
+		contactHash := api.hashPhone(contact)
+
+		var count int
+		err = api.sqlDB.Model(&exposure{}).
+			Where("contact_hash = ? AND created_at > ?", contactHash, now.Add(-api.isolationPeriod)).
+			Count(&count).Error
+		if err != nil {
+			return "", errors.Wrap(err, "failed to count contact exposures")
+		}
+		if count > 0 {
+			exposuresTotal.WithLabelValues("duplicate").Inc()
+			continue
+		}
+
+		// The exposure is only saved once the contact was told so that failed notifications can be sent again. It is
+		// saved after the request is over since the SMS is sent in the background.
+		sent := func(err error) {
+			if err != nil {
+				api.logger.Warningf("failed to send exposure notification: %v", err)
+				exposuresTotal.WithLabelValues("failed").Inc()
+				return
+			}
+			exposuresTotal.WithLabelValues("sent").Inc()
+			// The contact is checked on daily during the quarantine
+			err = api.sqlDB.Set(sqlContextKey, detachedContext{ctx}).Transaction(func(tx *gorm.DB) error {
+				notified := &exposure{TestResultID: isolation.ID, ReporterHash: reporterHash, ContactHash: contactHash}
+				err := tx.Create(notified).Error
+				if err != nil {
+					return errors.Wrap(err, "failed to save exposure")
+				}
+				return scheduleCheckIns(tx, 0, contactHash, notified.CreatedAt, notified.CreatedAt.Add(api.isolationPeriod))
+			})
+			if err != nil {
+				api.logger.Errorf("failed to save exposure: %v", err)
+			}
+		}
+
+		// The SMS doesn't say who reported the contact. The language of the contact is not known.
+		err = api.queueSMS(ctx, contact, messages.text("exposure_sms", eng)+"\n"+messages.text("exposure_sms", swa), sent)
+		if err != nil {
+			api.logger.Warningf("failed to queue exposure notification: %v", err)
+			exposuresTotal.WithLabelValues("failed").Inc()
+			continue
+		}
+		queued++
+	}
+
+	api.logger.Infof("queued %d of %d exposure notifications", queued, len(contacts))
+
+	return "END " + fmt.Sprintf(messages.text("exposure_sent", lang), len(contacts)), nil
+}
+
+// pendingExposure returns the latest exposure of the phone hash that has not been linked to a screening yet
+func (api *ussdAPIServer) pendingExposure(phoneHash string, now time.Time) (*exposure, error) {
+	notification := &exposure{}
+	err := api.sqlDB.Order("created_at DESC").
+		First(notification, "contact_hash = ? AND screening_id IS NULL AND created_at > ?", phoneHash, now.Add(-api.isolationPeriod)).Error
+	switch {
+	case err == nil:
+		return notification, nil
+	case gorm.IsRecordNotFoundError(err):
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to get exposure")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
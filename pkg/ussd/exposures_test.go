@@ -0,0 +1,167 @@
+package ussd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestExposureNotification(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	sms := api.sms.(*fakeSMS)
+	acceptConsent(t, api, "ATUid_notify")
+	for _, c := range []struct {
+		text   string
+		screen string
+	}{
+		{text: "1*1*7", screen: "CON We will send an SMS to your close contacts"},
+		{text: "1*1*7*1", screen: "CON Enter the phone number of a close contact"},
+		{text: "1*1*7*1*0700000001", screen: "CON Enter the number of a contact, not yours"},
+		{text: "1*1*7*1*0700000001*12345", screen: "CON Enter a mobile number like 0712345678"},
+		{text: "1*1*7*1*0700000001*12345*0712000001", screen: "CON Contact added\n1. Add another contact\n2. Send notifications"},
+		{text: "1*1*7*1*0700000001*12345*0712000001*1*0712000002*2", screen: "END Thank you. We will notify 2 contacts"},
+	} {
+		screen := send(t, api, "ATUid_notify", "*384#", c.text)
+		if !strings.HasPrefix(screen, c.screen) {
+			t.Errorf("%s: expected screen to start with %q, got %q", c.text, c.screen, screen)
+		}
+	}
+
+	if len(sms.messages) != 2 {
+		t.Fatalf("expected 2 exposure SMS, got %d", len(sms.messages))
+	}
+	if strings.Contains(sms.messages[0], "700000001") {
+		t.Errorf("expected anonymous exposure SMS, got %q", sms.messages[0])
+	}
+
+	// Contacts are notified once per isolation period
+	acceptConsent(t, api, "ATUid_notify_again")
+	send(t, api, "ATUid_notify_again", "*384#", "1*1*7*1*0712000001*2")
+	if len(sms.messages) != 2 {
+		t.Errorf("expected contact not to be notified twice, got %d SMS", len(sms.messages))
+	}
+}
+
+func TestFailedExposureNotificationIsSentAgain(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	sms := api.sms.(*fakeSMS)
+	sms.err = errors.New("gateway unavailable")
+	acceptConsent(t, api, "ATUid_notify")
+	send(t, api, "ATUid_notify", "*384#", "1*1*7*1*0712000001*2")
+
+	var count int
+	err := api.sqlDB.Model(&exposure{}).Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no exposure to be saved for a failed notification, got %d", count)
+	}
+
+	sms.err = nil
+	acceptConsent(t, api, "ATUid_notify_again")
+	send(t, api, "ATUid_notify_again", "*384#", "1*1*7*1*0712000001*2")
+	if len(sms.messages) != 1 {
+		t.Errorf("expected the contact to be notified on the second attempt, got %d SMS", len(sms.messages))
+	}
+}
+
+func TestExposureRequiresPositiveResult(t *testing.T) {
+	api := newTestAPI(t)
+
+	acceptConsent(t, api, "ATUid_notify")
+	screen := send(t, api, "ATUid_notify", "*384#", "1*1*7*1*0712000001*2")
+	if !strings.HasPrefix(screen, "END Only people who reported a positive test") {
+		t.Errorf("expected notification to be refused, got %q", screen)
+	}
+}
+
+func TestExposureDailyLimit(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	for i := 0; i < maxContactsPerDay; i++ {
+		err := api.sqlDB.Create(&exposure{TestResultID: 1, ReporterHash: api.hashPhone(testPhone), ContactHash: "contact"}).Error
+		if err != nil {
+			t.Fatalf("failed to save exposure: %v", err)
+		}
+	}
+
+	acceptConsent(t, api, "ATUid_notify")
+	screen := send(t, api, "ATUid_notify", "*384#", "1*1*7*1*0712000001*2")
+	if !strings.HasPrefix(screen, "END You can notify up to 20 contacts a day") {
+		t.Errorf("expected daily limit, got %q", screen)
+	}
+	if sms := api.sms.(*fakeSMS); len(sms.messages) != 0 {
+		t.Errorf("expected no exposure SMS, got %d", len(sms.messages))
+	}
+}
+
+func TestExposureDailyLimitAcrossSessions(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Each session notifies the most contacts a report can have until the daily limit is reached
+	var screen string
+	for session := 0; session*maxContactsPerReport <= maxContactsPerDay; session++ {
+		text := "1*1*7*1"
+		for i := 0; i < maxContactsPerReport; i++ {
+			if i > 0 {
+				text += "*1"
+			}
+			text += fmt.Sprintf("*07120%02d%03d", session, i)
+		}
+		sessionID := fmt.Sprintf("ATUid_notify_%d", session)
+		acceptConsent(t, api, sessionID)
+		screen = send(t, api, sessionID, "*384#", text+"*2")
+	}
+
+	if !strings.HasPrefix(screen, "END You can notify up to 20 contacts a day") {
+		t.Errorf("expected daily limit, got %q", screen)
+	}
+	if sms := api.sms.(*fakeSMS); len(sms.messages) != maxContactsPerDay {
+		t.Errorf("expected %d exposure SMS, got %d", maxContactsPerDay, len(sms.messages))
+	}
+}
+
+func TestKnownExposureFlagsNextScreening(t *testing.T) {
+	api := newTestAPI(t)
+
+	err := api.sqlDB.Create(&exposure{TestResultID: 1, ReporterHash: "reporter", ContactHash: api.hashPhone(testPhone)}).Error
+	if err != nil {
+		t.Fatalf("failed to save exposure: %v", err)
+	}
+
+	// Low risk answers are raised to medium risk
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "sw_screening.golden"))
+	for _, sessionID := range []string{"ATUid_exposed", "ATUid_later"} {
+		replay(t, api, sessionID, exchanges)
+	}
+
+	saved := &screening{}
+	err = api.sqlDB.First(saved, "session_id = ?", "ATUid_exposed").Error
+	if err != nil {
+		t.Fatalf("failed to get screening: %v", err)
+	}
+	if !saved.KnownExposure || saved.RiskBand != riskMedium {
+		t.Errorf("expected known exposure and band %s, got %v and %s", riskMedium, saved.KnownExposure, saved.RiskBand)
+	}
+
+	// Later screenings during quarantine are daily check-ins
+	var later int
+	api.sqlDB.Model(&screening{}).Where("session_id = ?", "ATUid_later").Count(&later)
+	if later != 0 {
+		t.Errorf("expected later screening to be a check-in, got %d screenings", later)
+	}
+}
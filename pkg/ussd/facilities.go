@@ -0,0 +1,392 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Facility services
+const (
+	FacilityTesting     = "testing"
+	FacilityVaccination = "vaccination"
+	FacilityIsolation   = "isolation"
+	FacilityTreatment   = "treatment"
+)
+
+const (
+	// maxScreenLength is the number of characters most gateways show on one screen
+	maxScreenLength = 182
+	// facilitiesSMSLimit is the most facilities sent in one SMS
+	facilitiesSMSLimit = 10
+	// facilitiesSMS is the input that sends the list by SMS
+	facilitiesSMS = "1"
+	// maxFacilitiesSize is the largest facilities file accepted by the admin API
+	maxFacilitiesSize = 10 << 20
+)
+
+// Facility is a testing centre or health facility shown in the facility locator
+type Facility struct {
+	ID     uint   `gorm:"primary_key" json:"-"`
+	County string `gorm:"type:varchar(50);unique_index:idx_facility;not null" json:"county"`
+	// SubCounty is empty for facilities serving the whole county
+	SubCounty string `gorm:"type:varchar(50);unique_index:idx_facility" json:"subCounty,omitempty"`
+	Name      string `gorm:"type:varchar(100);unique_index:idx_facility;not null" json:"name"`
+	// Services lists comma separated services offered, e.g testing,isolation
+	Services string `gorm:"type:varchar(100);not null" json:"services"`
+	// OpensAt and ClosesAt are in HH:MM East Africa Time. Empty means open all day.
+	OpensAt   string    `gorm:"type:varchar(5)" json:"opensAt,omitempty"`
+	ClosesAt  string    `gorm:"type:varchar(5)" json:"closesAt,omitempty"`
+	Phone     string    `gorm:"type:varchar(20)" json:"phone,omitempty"`
+	Disabled  bool      `json:"disabled,omitempty"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// TableName is the facilities table name
+func (*Facility) TableName() string {
+	return "ussd_facilities"
+}
+
+// Validate checks that the facility can be listed
+func (facility *Facility) Validate() error {
+	switch {
+	case facility.Name == "":
+		return errors.New("facility has no name")
+	case facility.County == "":
+		return errors.Errorf("facility %q has no county", facility.Name)
+	case facility.Services == "":
+		return errors.Errorf("facility %q has no services", facility.Name)
+	}
+
+	for _, service := range facility.services() {
+		switch service {
+		case FacilityTesting, FacilityVaccination, FacilityIsolation, FacilityTreatment:
+		default:
+			return errors.Errorf("facility %q has unknown service %q", facility.Name, service)
+		}
+	}
+
+	for _, t := range []string{facility.OpensAt, facility.ClosesAt} {
+		if t == "" {
+			continue
+		}
+		if !validClock(t) {
+			return errors.Errorf("facility %q has invalid operating hours %q, use HH:MM like 08:00", facility.Name, t)
+		}
+	}
+
+	return nil
+}
+
+func (facility *Facility) services() []string {
+	services := make([]string, 0)
+	for _, service := range strings.Split(facility.Services, ",") {
+		if service = strings.TrimSpace(service); service != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// text returns the facility as one line, e.g Mbagathi Hospital 0722000000 (testing, isolation; 08:00-17:00)
+func (facility *Facility) text(messages Messages, lang string) string {
+	services := make([]string, 0)
+	for _, service := range facility.services() {
+		services = append(services, messages.text("facility_"+service, lang))
+	}
+
+	hours := messages.text("facility_all_day", lang)
+	if facility.OpensAt != "" && facility.ClosesAt != "" {
+		hours = facility.OpensAt + "-" + facility.ClosesAt
+	}
+
+	line := facility.Name
+	if facility.Phone != "" {
+		line += " " + facility.Phone
+	}
+	return fmt.Sprintf("%s (%s; %s)", line, strings.Join(services, ", "), hours)
+}
+
+// parseFacilities reads facilities from a CSV file with county, sub_county, name, services, opens_at, closes_at and
+// phone columns or from a JSON array
+func parseFacilities(r *http.Request) ([]*Facility, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	facilities := make([]*Facility, 0)
+	switch mediaType {
+	case "application/json":
+		err := json.NewDecoder(r.Body).Decode(&facilities)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse json")
+		}
+	case "text/csv":
+		records, err := readCSV(r.Body, "county", "name", "services")
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			disabled, _ := strconv.ParseBool(record["disabled"])
+			facilities = append(facilities, &Facility{
+				County:    record["county"],
+				SubCounty: record["sub_county"],
+				Name:      record["name"],
+				Services:  record["services"],
+				OpensAt:   record["opens_at"],
+				ClosesAt:  record["closes_at"],
+				Phone:     record["phone"],
+				Disabled:  disabled,
+			})
+		}
+	default:
+		return nil, errors.Errorf("unsupported content type %q, use text/csv or application/json", r.Header.Get("Content-Type"))
+	}
+
+	for index, facility := range facilities {
+		facility.County = strings.TrimSpace(facility.County)
+		facility.SubCounty = strings.TrimSpace(facility.SubCounty)
+		facility.Name = strings.TrimSpace(facility.Name)
+		err := facility.Validate()
+		if err != nil {
+			return nil, errors.Wrapf(err, "row %d", index+1)
+		}
+	}
+
+	return facilities, nil
+}
+
+// saveFacilities saves the facilities, replacing facilities with the same name in the same county and sub-county
+func saveFacilities(db *gorm.DB, facilities []*Facility) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, facility := range facilities {
+			saved := &Facility{}
+			err := tx.Where("county = ? AND sub_county = ? AND name = ?", facility.County, facility.SubCounty, facility.Name).
+				Assign(map[string]interface{}{
+					"services":  facility.Services,
+					"opens_at":  facility.OpensAt,
+					"closes_at": facility.ClosesAt,
+					"phone":     facility.Phone,
+					"disabled":  facility.Disabled,
+				}).
+				FirstOrCreate(saved, Facility{County: facility.County, SubCounty: facility.SubCounty, Name: facility.Name}).Error
+			if err != nil {
+				return errors.Wrapf(err, "failed to save facility %q", facility.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// facilities imports facilities with POST and lists them with GET
+func (admin *adminAPIServer) facilities(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxFacilitiesSize)
+		facilities, err := parseFacilities(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = saveFacilities(admin.sqlDB, facilities)
+		if err != nil {
+			admin.internalError(w, "failed to save facilities", err)
+			return
+		}
+
+		admin.logger.Infof("imported %d facilities", len(facilities))
+		writeJSON(w, map[string]int{"imported": len(facilities)})
+
+	case http.MethodGet:
+		facilities := make([]*Facility, 0)
+		err := admin.sqlDB.Order("county, sub_county, name").Find(&facilities).Error
+		if err != nil {
+			admin.internalError(w, "failed to get facilities", err)
+			return
+		}
+		writeJSON(w, facilities)
+
+	default:
+		http.Error(w, "only GET and POST methods allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// findFacilities returns the facilities of the county with the ones in the sub-county first
+func (api *ussdAPIServer) findFacilities(county, subCounty string) ([]*Facility, error) {
+	facilities := make([]*Facility, 0)
+	err := api.sqlDB.Order("name").
+		Find(&facilities, "LOWER(county) = ? AND disabled = ?", strings.ToLower(county), false).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get facilities")
+	}
+
+	nearest := make([]*Facility, 0, len(facilities))
+	for _, facility := range facilities {
+		if strings.EqualFold(facility.SubCounty, subCounty) {
+			nearest = append(nearest, facility)
+		}
+	}
+	for _, facility := range facilities {
+		if !strings.EqualFold(facility.SubCounty, subCounty) {
+			nearest = append(nearest, facility)
+		}
+	}
+
+	return nearest, nil
+}
+
+// handleFacilities lets the user pick a county and sub-county and lists the facilities near them
+func (api *ussdAPIServer) handleFacilities(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages = ussd.content.Messages
+		county   = &Question{
+			ID:       "county",
+			Type:     QuestionCounty,
+			Text:     map[string]string{lang: messages.text("facilities_county", lang)},
+			PageSize: 7,
+		}
+		subCounty = &Question{
+			ID:       "sub_county",
+			Type:     QuestionSubCounty,
+			Within:   county.ID,
+			Text:     map[string]string{lang: messages.text("facilities_sub_county", lang)},
+			PageSize: 7,
+		}
+		flow       = newInputFlow(ussd, lang, county)
+		answers    = flow.qc.answers
+		facilities []*Facility
+		title      string
+		pages      [][]string
+		page       int
+	)
+
+	response, err := flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		flow.qc.page = 0
+		if flow.question == county {
+			flow.question = subCounty
+			return "", nil
+		}
+		flow.question = nil
+		page = 0
+		var err error
+		facilities, err = api.findFacilities(answers[county.ID][0], answers[subCounty.ID][0])
+		title = fmt.Sprintf(messages.text("facilities_title", lang), answers[subCounty.ID][0])
+		pages = facilityPages(facilities, messages, lang, title)
+		return "", err
+	}, func(input string, last bool) (string, error) {
+		switch input {
+		case strconv.Itoa(pageMore):
+			if page+1 < len(pages) {
+				page++
+			}
+		case pageBack:
+			// Back from the first screen returns to the sub-county menu
+			if page == 0 {
+				flow.question = subCounty
+				break
+			}
+			page--
+		case facilitiesSMS:
+			if !last {
+				break
+			}
+			w.stage = "facilities_sms"
+			if len(facilities) > facilitiesSMSLimit {
+				facilities = facilities[:facilitiesSMSLimit]
+			}
+			lines := []string{fmt.Sprintf(messages.text("facilities_sms_title", lang), answers[subCounty.ID][0])}
+			for _, facility := range facilities {
+				lines = append(lines, facility.text(messages, lang))
+			}
+			err := api.queueSMS(ctx, ussd.PhoneNumber, strings.Join(lines, "\n"), nil)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to send facilities")
+			}
+			return "END " + messages.text("facilities_sms_sent", lang), nil
+		}
+		return "", nil
+	})
+	if response != "" || err != nil {
+		return response, err
+	}
+
+	if flow.question != nil {
+		return flow.render(), nil
+	}
+
+	w.stage = "facilities"
+	if len(facilities) == 0 {
+		return "END " + fmt.Sprintf(messages.text("facilities_none", lang), answers[county.ID][0]), nil
+	}
+
+	lines := []string{title + fmt.Sprintf(" (%d/%d)", page+1, len(pages))}
+	lines = append(lines, pages[page]...)
+	lines = append(lines, fmt.Sprintf("%s. %s", facilitiesSMS, messages.text("facilities_sms", lang)))
+	if page+1 < len(pages) {
+		lines = append(lines, fmt.Sprintf("%d. %s", pageMore, messages.text("page_more", lang)))
+	}
+	lines = append(lines, fmt.Sprintf("%s. %s", pageBack, messages.text("page_back", lang)))
+
+	return "CON " + strings.Join(lines, "\n"), nil
+}
+
+// facilityPages splits the facility lines into screens that fit maxScreenLength with the title, the page numbers and
+// all the options. A facility too long for a screen of its own is shown with a shortened name.
+func facilityPages(facilities []*Facility, messages Messages, lang, title string) [][]string {
+	// The title has the widest page numbers a list of facilities can have
+	room := maxScreenLength - len(title+" (99/99)")
+	for _, option := range []string{
+		fmt.Sprintf("%s. %s", facilitiesSMS, messages.text("facilities_sms", lang)),
+		fmt.Sprintf("%d. %s", pageMore, messages.text("page_more", lang)),
+		fmt.Sprintf("%s. %s", pageBack, messages.text("page_back", lang)),
+	} {
+		room -= 1 + len(option)
+	}
+
+	var (
+		pages = make([][]string, 0)
+		page  = make([]string, 0)
+		used  int
+	)
+	for _, facility := range facilities {
+		line := facility.text(messages, lang)
+		if excess := 1 + len(line) - room; excess > 0 {
+			// The name is cut so that the phone, services and hours are still shown
+			short := *facility
+			short.Name = shorten(facility.Name, len(facility.Name)-excess)
+			line = short.text(messages, lang)
+		}
+		if len(page) > 0 && used+1+len(line) > room {
+			pages = append(pages, page)
+			page, used = make([]string, 0), 0
+		}
+		page = append(page, line)
+		used += 1 + len(line)
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// shorten cuts the text to at most max bytes ending with "..." without breaking characters
+func shorten(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	end := 0
+	for i := range text {
+		if i > max-len("...") {
+			break
+		}
+		end = i
+	}
+	return text[:end] + "..."
+}
@@ -0,0 +1,88 @@
+package ussd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testFacilities = `county,sub_county,name,services,opens_at,closes_at,phone
+Nairobi,Kibra,Kibra Health Centre,"testing,treatment",08:00,17:00,0711000001
+Nairobi,Langata,Langata Health Centre,testing,08:00,17:00,0711000002
+Nairobi,,Kenyatta National Hospital,"testing,isolation,treatment",,,0711000003
+Nairobi,Kibra,Closed Clinic,testing,,,0711000004
+Mombasa,Mvita,Coast General Hospital,"testing,isolation",,,0711000005
+`
+
+func TestFacilityLocator(t *testing.T) {
+	api := newTestAPI(t)
+	admin := newTestAdmin(t, api)
+
+	rec := adminPost(admin, "/api/ussd/facilities", "text/csv", testFacilities)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Importing again updates facilities instead of adding them
+	rec = adminPost(admin, "/api/ussd/facilities", "application/json",
+		`[{"county": "Nairobi", "subCounty": "Kibra", "name": "Closed Clinic", "services": "testing", "disabled": true}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int
+	err := api.sqlDB.Model(&Facility{}).Count(&count).Error
+	if err != nil {
+		t.Fatalf("failed to count facilities: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 facilities, got %d", count)
+	}
+
+	// Nairobi is county 30 and Kibra is sub-county 5. Facilities in the sub-county come first and are shown as many
+	// as fit on a screen.
+	var screens []string
+	for _, text := range []string{"1*1*5*30*5", "1*1*5*30*5*98", "1*1*5*30*5*98*98"} {
+		screens = append(screens, send(t, api, "ATUid_facilities", "*384#", text))
+	}
+	for i, want := range []string{
+		"Facilities near Kibra (1/3)\nKibra Health Centre 0711000001 (testing, treatment; 08:00-17:00)\n1. SMS me the list\n98. More",
+		"Facilities near Kibra (2/3)\nKenyatta National Hospital 0711000003 (testing, isolation, treatment; 24 hours)\n",
+		"Facilities near Kibra (3/3)\nLangata Health Centre 0711000002 (testing; 08:00-17:00)\n1. SMS me the list\n0. Back",
+	} {
+		if !strings.Contains(screens[i], want) {
+			t.Errorf("expected facilities screen %d to contain %q, got %q", i+1, want, screens[i])
+		}
+		if strings.Contains(screens[i], "Closed Clinic") || strings.Contains(screens[i], "Coast General") {
+			t.Errorf("expected only open facilities of the county, got %q", screens[i])
+		}
+	}
+
+	screen := send(t, api, "ATUid_facilities", "*384#", "1*1*5*30*5*98*98*1")
+	if !strings.HasPrefix(screen, "END ") {
+		t.Fatalf("expected session to end after sending the list, got %q", screen)
+	}
+	sms := api.sms.(*fakeSMS)
+	if len(sms.messages) != 1 || strings.Count(sms.messages[0], "\n") != 3 {
+		t.Errorf("expected one SMS with a title and 3 facilities, got %q", sms.messages)
+	}
+}
+
+func TestImportFacilitiesRejectsInvalidFiles(t *testing.T) {
+	api := newTestAPI(t)
+	admin := newTestAdmin(t, api)
+
+	for _, c := range []struct {
+		name string
+		body string
+	}{
+		{name: "missing column", body: "county,name\nNairobi,Kibra Health Centre\n"},
+		{name: "unknown service", body: "county,name,services\nNairobi,Kibra Health Centre,surgery\n"},
+		{name: "invalid hours", body: "county,name,services,opens_at\nNairobi,Kibra Health Centre,testing,8am\n"},
+		{name: "hours not zero padded", body: "county,name,services,opens_at\nNairobi,Kibra Health Centre,testing,8:00\n"},
+	} {
+		rec := adminPost(admin, "/api/ussd/facilities", "text/csv", c.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", c.name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxPINAttempts is the number of wrong PINs after which the health worker is locked out
+	maxPINAttempts = 5
+	// pinLockout is how long a health worker is locked out after too many wrong PINs
+	pinLockout = 30 * time.Minute
+	// modeHealthWorker is the session mode of screenings done by a health worker on behalf of someone else
+	modeHealthWorker = "health_worker"
+	// workerKey is the session key holding the id of the authenticated health worker
+	workerKey = "worker"
+	// workerInputsKey is the session key holding the number of inputs used to authenticate
+	workerInputsKey = "worker_inputs"
+	// householdKey is the session key holding the household id attached to the screening
+	householdKey = "household"
+	// Inputs of the health worker menu
+	workerScreen  = "1"
+	workerSummary = "2"
+)
+
+// healthWorker is a community health volunteer registered to screen people without phones. Only hashes of the
+// phone number and PIN are stored.
+type healthWorker struct {
+	ID             uint   `gorm:"primary_key"`
+	Name           string `gorm:"type:varchar(100);not null"`
+	PhoneHash      string `gorm:"type:varchar(64);unique_index;not null"`
+	PINHash        string `gorm:"type:varchar(100);not null"`
+	FailedAttempts int
+	LockedUntil    *time.Time
+	Disabled       bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (*healthWorker) TableName() string {
+	return "ussd_health_workers"
+}
+
+// healthWorkerRegistration is one health worker in the admin API
+type healthWorkerRegistration struct {
+	ID       uint   `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Phone    string `json:"phone,omitempty"`
+	PIN      string `json:"pin,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+	// LockedUntil is set in listings of health workers locked out after wrong PINs
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// validPIN checks that the PIN is 4 to 6 digits
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 6 {
+		return false
+	}
+	_, err := strconv.ParseUint(pin, 10, 32)
+	return err == nil
+}
+
+// healthWorkers registers health workers with POST and lists them with GET. Registering a phone that is already
+// registered replaces the name and PIN and lifts the lockout.
+func (admin *adminAPIServer) healthWorkers(w http.ResponseWriter, r *http.Request) {
+	if len(admin.phoneHashKey) == 0 {
+		http.Error(w, "phone hash key is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		registrations := make([]*healthWorkerRegistration, 0)
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&registrations)
+		if err != nil {
+			http.Error(w, "failed to parse json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for index, registration := range registrations {
+			_, invalidPhone := phoneType{}.parse(nil, nil, registration.Phone)
+			switch {
+			case strings.TrimSpace(registration.Name) == "":
+				http.Error(w, fmt.Sprintf("row %d: missing name", index+1), http.StatusBadRequest)
+				return
+			case invalidPhone != nil:
+				http.Error(w, fmt.Sprintf("row %d: invalid phone %q", index+1, registration.Phone), http.StatusBadRequest)
+				return
+			case !validPIN(registration.PIN):
+				http.Error(w, fmt.Sprintf("row %d: pin must be 4 to 6 digits", index+1), http.StatusBadRequest)
+				return
+			}
+		}
+
+		err = admin.sqlDB.Transaction(func(tx *gorm.DB) error {
+			for _, registration := range registrations {
+				pinHash, err := bcrypt.GenerateFromPassword([]byte(registration.PIN), bcrypt.DefaultCost)
+				if err != nil {
+					return errors.Wrap(err, "failed to hash pin")
+				}
+				phoneHash := hashPhoneWithKey(admin.phoneHashKey, registration.Phone)
+				err = tx.Where(&healthWorker{PhoneHash: phoneHash}).
+					Assign(map[string]interface{}{
+						"name":            strings.TrimSpace(registration.Name),
+						"pin_hash":        string(pinHash),
+						"disabled":        registration.Disabled,
+						"failed_attempts": 0,
+						"locked_until":    nil,
+					}).
+					FirstOrCreate(&healthWorker{}).Error
+				if err != nil {
+					return errors.Wrapf(err, "failed to save health worker %q", registration.Name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			admin.internalError(w, "failed to save health workers", err)
+			return
+		}
+
+		admin.logger.Infof("registered %d health workers", len(registrations))
+		writeJSON(w, map[string]int{"registered": len(registrations)})
+
+	case http.MethodGet:
+		workers := make([]*healthWorker, 0)
+		err := admin.sqlDB.Order("name").Find(&workers).Error
+		if err != nil {
+			admin.internalError(w, "failed to get health workers", err)
+			return
+		}
+
+		now := time.Now()
+		registrations := make([]*healthWorkerRegistration, 0, len(workers))
+		for _, worker := range workers {
+			registration := &healthWorkerRegistration{ID: worker.ID, Name: worker.Name, Disabled: worker.Disabled}
+			if worker.LockedUntil != nil && worker.LockedUntil.After(now) {
+				registration.LockedUntil = worker.LockedUntil
+			}
+			registrations = append(registrations, registration)
+		}
+		writeJSON(w, registrations)
+
+	default:
+		http.Error(w, "only GET and POST methods allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkPIN compares the PIN with the one of the health worker and locks the health worker out after
+// maxPINAttempts wrong PINs. It returns the number of attempts left when the PIN is wrong.
+func (api *ussdAPIServer) checkPIN(worker *healthWorker, pin string, now time.Time) (bool, int, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(worker.PINHash), []byte(pin))
+	if err == nil {
+		err = api.sqlDB.Model(worker).Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error
+		if err != nil {
+			return false, 0, errors.Wrap(err, "failed to reset pin attempts")
+		}
+		return true, 0, nil
+	}
+
+	updates := map[string]interface{}{"failed_attempts": worker.FailedAttempts + 1}
+	left := maxPINAttempts - worker.FailedAttempts - 1
+	if left <= 0 {
+		updates["failed_attempts"] = 0
+		updates["locked_until"] = now.Add(pinLockout)
+	}
+	err = api.sqlDB.Model(worker).Updates(updates).Error
+	if err != nil {
+		return false, 0, errors.Wrap(err, "failed to save pin attempt")
+	}
+
+	return false, left, nil
+}
+
+// handleHealthWorker authenticates registered health workers with their PIN and lets them screen people on
+// behalf of a household or see the screenings they did today. Screenings continue in handleScreening.
+func (api *ussdAPIServer) handleHealthWorker(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	messages := ussd.content.Messages
+
+	session, err := api.getUserFromSession(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user session")
+	}
+	if session[modeKey] == modeHealthWorker {
+		return api.handleScreening(ctx, w, ussd)
+	}
+
+	now := time.Now()
+	worker := &healthWorker{}
+	err = api.sqlDB.First(worker, "phone_hash = ? AND disabled = ?", api.hashPhone(ussd.PhoneNumber), false).Error
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		w.stage = "worker_unregistered"
+		healthWorkerLoginsTotal.WithLabelValues("unregistered").Inc()
+		return "END " + messages.text("worker_unregistered", lang), nil
+	case err != nil:
+		return "", errors.Wrap(err, "failed to get health worker")
+	}
+
+	inputs := serviceInputs(ussd)
+
+	// Only the last input is checked as a PIN so that wrong PINs are not counted again on the next requests
+	if session[workerKey] != strconv.Itoa(int(worker.ID)) {
+		if worker.LockedUntil != nil && worker.LockedUntil.After(now) {
+			w.stage = "worker_locked"
+			healthWorkerLoginsTotal.WithLabelValues("locked").Inc()
+			return "END " + fmt.Sprintf(messages.text("worker_locked", lang), int(pinLockout.Minutes())), nil
+		}
+		if len(inputs) == 0 {
+			w.stage = "worker_pin"
+			return "CON " + messages.text("worker_pin", lang), nil
+		}
+
+		ok, left, err := api.checkPIN(worker, inputs[len(inputs)-1], now)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			w.stage = "worker_wrong_pin"
+			healthWorkerLoginsTotal.WithLabelValues("wrong_pin").Inc()
+			if left <= 0 {
+				api.logger.Warningf("health worker %d locked out after %d wrong pins", worker.ID, maxPINAttempts)
+				return "END " + fmt.Sprintf(messages.text("worker_locked", lang), int(pinLockout.Minutes())), nil
+			}
+			return "CON " + fmt.Sprintf(messages.text("worker_wrong_pin", lang), left) + "\n" + messages.text("worker_pin", lang), nil
+		}
+
+		healthWorkerLoginsTotal.WithLabelValues("success").Inc()
+		err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+			workerKey:       strconv.Itoa(int(worker.ID)),
+			workerInputsKey: strconv.Itoa(len(inputs)),
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to save health worker")
+		}
+		session[workerInputsKey] = strconv.Itoa(len(inputs))
+	}
+
+	var (
+		skip, _   = strconv.Atoi(session[workerInputsKey])
+		menu      = workerMenu(messages, lang)
+		household = &Question{
+			ID:        "household",
+			Type:      QuestionText,
+			Text:      map[string]string{lang: messages.text("worker_household", lang)},
+			MinLength: 1,
+			MaxLength: 20,
+		}
+		flow = newInputFlow(ussd, lang, menu)
+	)
+	if skip > len(inputs) {
+		skip = len(inputs)
+	}
+
+	response, err := flow.replay(inputs[skip:], func(ans *answer, last bool) (string, error) {
+		switch flow.question {
+		case menu:
+			if ans.values[0] == workerSummary {
+				w.stage = "worker_summary"
+				return api.workerSummary(worker, messages, lang, now)
+			}
+			flow.question = household
+		case household:
+			if !last {
+				break
+			}
+			w.stage = "worker_screening_start"
+			return api.startWorkerScreening(ussd, worker, strings.ToUpper(ans.values[0]), lang)
+		}
+		return "", nil
+	}, nil)
+	if response != "" || err != nil {
+		return response, err
+	}
+
+	w.stage = "worker_" + flow.question.ID
+	return flow.render(), nil
+}
+
+func workerMenu(messages Messages, lang string) *Question {
+	return &Question{
+		ID:   "menu",
+		Text: map[string]string{lang: messages.text("worker_menu", lang)},
+		Options: []*Option{
+			{Text: map[string]string{lang: messages.text("worker_screen", lang)}, Value: workerScreen},
+			{Text: map[string]string{lang: messages.text("worker_summary_option", lang)}, Value: workerSummary},
+		},
+	}
+}
+
+// startWorkerScreening starts the questionnaire for a member of the household. The next requests go to
+// handleScreening through the session mode.
+func (api *ussdAPIServer) startWorkerScreening(ussd *ussdPayload, worker *healthWorker, household, lang string) (string, error) {
+	questionnaire := ussd.content.Questionnaire
+	first := questionnaire.Questions[0]
+
+	err := api.sessions.SetAll(ussd.SessionID, map[string]string{
+		modeKey:          modeHealthWorker,
+		householdKey:     household,
+		questionKey:      first.ID,
+		questionnaireKey: questionnaire.Version,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start screening")
+	}
+
+	api.logger.Infof("health worker %d started a screening for household %s", worker.ID, household)
+
+	return questionnaire.render(first, api.questionContext(ussd, nil), lang, "", true), nil
+}
+
+// workerSummary counts the screenings the health worker did today by risk band
+func (api *ussdAPIServer) workerSummary(worker *healthWorker, messages Messages, lang string, now time.Time) (string, error) {
+	var rows []struct {
+		RiskBand string
+		Count    int
+	}
+	err := api.sqlDB.Model(&screening{}).
+		Select("risk_band, COUNT(*) AS count").
+		Where("health_worker_id = ? AND created_at >= ?", worker.ID, today(now)).
+		Group("risk_band").
+		Scan(&rows).Error
+	if err != nil {
+		return "", errors.Wrap(err, "failed to count screenings")
+	}
+
+	var households int
+	err = api.sqlDB.Model(&screening{}).
+		Where("health_worker_id = ? AND created_at >= ?", worker.ID, today(now)).
+		Select("COUNT(DISTINCT household_id)").
+		Row().Scan(&households)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to count households")
+	}
+
+	bands := make(map[string]int)
+	total := 0
+	for _, row := range rows {
+		bands[row.RiskBand] = row.Count
+		total += row.Count
+	}
+
+	return "END " + fmt.Sprintf(messages.text("worker_summary", lang),
+		total, households, bands[riskHigh], bands[riskMedium], bands[riskLow]), nil
+}
@@ -0,0 +1,126 @@
+package ussd
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func registerHealthWorker(t *testing.T, api *ussdAPIServer) {
+	t.Helper()
+
+	rec := adminPost(newTestAdmin(t, api), "/api/ussd/health-workers", "application/json",
+		`[{"name": "Amina", "phone": "0700000001", "pin": "4321"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRegisterHealthWorker(t *testing.T) {
+	api := newTestAPI(t)
+	registerHealthWorker(t, api)
+
+	worker := &healthWorker{}
+	err := api.sqlDB.First(worker).Error
+	if err != nil {
+		t.Fatalf("failed to get health worker: %v", err)
+	}
+	if worker.PhoneHash != api.hashPhone(testPhone) || worker.PINHash == "4321" || worker.PINHash == "" {
+		t.Errorf("expected phone and pin to be hashed, got %+v", worker)
+	}
+
+	for _, body := range []string{
+		`[{"name": "Amina", "phone": "0700000001", "pin": "12"}]`,
+		`[{"name": "Amina", "phone": "12345", "pin": "4321"}]`,
+		`[{"phone": "0700000001", "pin": "4321"}]`,
+	} {
+		rec := adminPost(newTestAdmin(t, api), "/api/ussd/health-workers", "application/json", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestHealthWorkerScreening(t *testing.T) {
+	api := newTestAPI(t)
+	registerHealthWorker(t, api)
+
+	texts := []struct {
+		text   string
+		screen string
+	}{
+		{text: "", screen: "CON Welcome"},
+		{text: "1", screen: "CON KoviTrace uses your answers"},
+		{text: "1*1", screen: "CON Select service"},
+		{text: "1*1*8", screen: "CON Enter your health worker PIN"},
+		{text: "1*1*8*1111", screen: "CON Wrong PIN. 4 attempts left\nEnter your health worker PIN"},
+		{text: "1*1*8*1111*4321", screen: "CON Health worker menu\n1. Screen a person\n2. My screenings today"},
+		{text: "1*1*8*1111*4321*1", screen: "CON Enter the household ID"},
+	}
+	for _, c := range texts {
+		screen := send(t, api, "ATUid_worker", "*384#", c.text)
+		if !strings.HasPrefix(screen, c.screen) {
+			t.Errorf("%q: expected screen to start with %q, got %q", c.text, c.screen, screen)
+		}
+	}
+
+	// The questionnaire is the same as self-screening
+	text := "1*1*8*1111*4321*1*hh-12"
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_screening.golden"))
+	screen := send(t, api, "ATUid_worker", "*384#", text)
+	if screen != exchanges[3].screen {
+		t.Errorf("expected first question, got %q", screen)
+	}
+	for _, ex := range exchanges[4:] {
+		text += "*" + ex.input
+		screen = send(t, api, "ATUid_worker", "*384#", text)
+	}
+	if !strings.HasPrefix(screen, "END You have") {
+		t.Fatalf("expected risk result, got %q", screen)
+	}
+
+	saved := &screening{}
+	err := api.sqlDB.First(saved, "session_id = ?", "ATUid_worker").Error
+	if err != nil {
+		t.Fatalf("failed to get screening: %v", err)
+	}
+	if saved.HealthWorkerID == nil || saved.HouseholdID != "HH-12" || saved.PhoneHash != "" {
+		t.Errorf("expected screening on behalf of household HH-12, got %+v", saved)
+	}
+
+	var summary string
+	for _, text := range []string{"", "1", "1*1", "1*1*8", "1*1*8*4321", "1*1*8*4321*2"} {
+		summary = send(t, api, "ATUid_summary", "*384#", text)
+	}
+	want := "END Screenings today: 1 in 1 households\nHIGH: "
+	if !strings.HasPrefix(summary, want) {
+		t.Errorf("expected summary to start with %q, got %q", want, summary)
+	}
+}
+
+func TestHealthWorkerLockout(t *testing.T) {
+	api := newTestAPI(t)
+	registerHealthWorker(t, api)
+
+	text := "1*1*8"
+	var screen string
+	for _, input := range []string{"", "1", "1*1"} {
+		send(t, api, "ATUid_guess", "*384#", input)
+	}
+	for i := 0; i < maxPINAttempts; i++ {
+		text += "*0000"
+		screen = send(t, api, "ATUid_guess", "*384#", text)
+	}
+	if !strings.HasPrefix(screen, "END Too many wrong PINs") {
+		t.Fatalf("expected lockout, got %q", screen)
+	}
+
+	// The right PIN is refused until the lockout ends
+	for _, input := range []string{"", "1", "1*1", "1*1*8", "1*1*8*4321"} {
+		screen = send(t, api, "ATUid_locked", "*384#", input)
+	}
+	if !strings.HasPrefix(screen, "END Too many wrong PINs. Try again in 30 minutes") {
+		t.Errorf("expected locked out health worker, got %q", screen)
+	}
+}
@@ -0,0 +1,284 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Hotline categories
+const (
+	HotlineMinistry     = "ministry"
+	HotlineCounty       = "county"
+	HotlineMentalHealth = "mental_health"
+	HotlineGBV          = "gbv"
+)
+
+// countyQuestion validates county names typed by users
+var countyQuestion = &Question{ID: "county", Type: QuestionText, MinLength: 3, MaxLength: 30}
+
+// eastAfricaTime is the timezone of hotline operating hours
+var eastAfricaTime = time.FixedZone("EAT", 3*60*60)
+
+// Hotline is a phone line shown to users
+type Hotline struct {
+	ID       uint   `gorm:"primary_key" yaml:"-"`
+	Category string `gorm:"type:varchar(20);index;not null" yaml:"category"`
+	// County is set for county hotlines
+	County string `gorm:"type:varchar(50);index" yaml:"county,omitempty"`
+	Label  string `gorm:"type:varchar(50);not null" yaml:"label"`
+	Number string `gorm:"type:varchar(20);not null" yaml:"number"`
+	// Languages lists comma separated language codes the line is answered in. Empty means all languages.
+	Languages string `gorm:"type:varchar(20)" yaml:"languages,omitempty"`
+	// OpensAt and ClosesAt are in HH:MM East Africa Time. Empty means open all day.
+	OpensAt  string `gorm:"type:varchar(5)" yaml:"opensAt,omitempty"`
+	ClosesAt string `gorm:"type:varchar(5)" yaml:"closesAt,omitempty"`
+	// Priority orders hotlines, higher first
+	Priority  int       `yaml:"priority,omitempty"`
+	Disabled  bool      `yaml:"disabled,omitempty"`
+	UpdatedAt time.Time `yaml:"-"`
+}
+
+// TableName is the hotlines table name
+func (*Hotline) TableName() string {
+	return "ussd_hotlines"
+}
+
+// Validate checks that the hotline can be shown
+func (hotline *Hotline) Validate() error {
+	switch {
+	case hotline.Category == "":
+		return errors.New("missing hotline category")
+	case hotline.Category == HotlineCounty && hotline.County == "":
+		return errors.Errorf("county hotline %q has no county", hotline.Number)
+	case hotline.Label == "":
+		return errors.Errorf("hotline %q has no label", hotline.Number)
+	case hotline.Number == "":
+		return errors.Errorf("hotline %q has no number", hotline.Label)
+	}
+
+	for _, t := range []string{hotline.OpensAt, hotline.ClosesAt} {
+		if t == "" {
+			continue
+		}
+		if !validClock(t) {
+			return errors.Errorf("hotline %q has invalid operating hours %q, use HH:MM like 08:00", hotline.Number, t)
+		}
+	}
+
+	return nil
+}
+
+func (hotline *Hotline) speaks(lang string) bool {
+	if hotline.Languages == "" {
+		return true
+	}
+	for _, l := range strings.Split(hotline.Languages, ",") {
+		if strings.TrimSpace(l) == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// validClock reports whether t is a time of day in the zero padded HH:MM form that openAt compares as a string
+func validClock(t string) bool {
+	parsed, err := time.Parse("15:04", t)
+	return err == nil && parsed.Format("15:04") == t
+}
+
+func (hotline *Hotline) openAt(now time.Time) bool {
+	if hotline.OpensAt == "" || hotline.ClosesAt == "" {
+		return true
+	}
+
+	clock := now.In(eastAfricaTime).Format("15:04")
+
+	// Lines open overnight close the next day
+	if hotline.ClosesAt < hotline.OpensAt {
+		return clock >= hotline.OpensAt || clock < hotline.ClosesAt
+	}
+	return clock >= hotline.OpensAt && clock < hotline.ClosesAt
+}
+
+// LoadHotlines reads hotlines from a yaml file
+func LoadHotlines(file string) ([]*Hotline, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read hotlines file")
+	}
+
+	var content struct {
+		Hotlines []*Hotline `yaml:"hotlines"`
+	}
+	err = yaml.Unmarshal(data, &content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse hotlines file")
+	}
+
+	for _, hotline := range content.Hotlines {
+		err = hotline.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content.Hotlines, nil
+}
+
+// hotlineDirectory is the in-memory copy of the hotlines table
+type hotlineDirectory struct {
+	mu       sync.RWMutex
+	hotlines []*Hotline
+}
+
+func (dir *hotlineDirectory) set(hotlines []*Hotline) {
+	sort.SliceStable(hotlines, func(i, j int) bool {
+		return hotlines[i].Priority > hotlines[j].Priority
+	})
+
+	dir.mu.Lock()
+	dir.hotlines = hotlines
+	dir.mu.Unlock()
+}
+
+// find returns hotlines in the category that are open now and answered in the language
+func (dir *hotlineDirectory) find(category, county, lang string, now time.Time) []*Hotline {
+	dir.mu.RLock()
+	defer dir.mu.RUnlock()
+
+	hotlines := make([]*Hotline, 0)
+	for _, hotline := range dir.hotlines {
+		switch {
+		case hotline.Category != category:
+		case county != "" && !strings.EqualFold(hotline.County, county):
+		case !hotline.speaks(lang):
+		case !hotline.openAt(now):
+		default:
+			hotlines = append(hotlines, hotline)
+		}
+	}
+
+	return hotlines
+}
+
+// seedHotlines saves the hotlines if the hotlines table is empty
+func (api *ussdAPIServer) seedHotlines(hotlines []*Hotline) error {
+	var count int
+	err := api.sqlDB.Model(&Hotline{}).Count(&count).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to count hotlines")
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, hotline := range hotlines {
+		err = api.sqlDB.Create(hotline).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to seed hotlines")
+		}
+	}
+
+	return nil
+}
+
+func (api *ussdAPIServer) reloadHotlines() error {
+	hotlines := make([]*Hotline, 0)
+	err := api.sqlDB.Find(&hotlines, "disabled = ?", false).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to get hotlines")
+	}
+
+	valid := make([]*Hotline, 0, len(hotlines))
+	for _, hotline := range hotlines {
+		err = hotline.Validate()
+		if err != nil {
+			api.logger.Warningf("skipping hotline %d: %v", hotline.ID, err)
+			continue
+		}
+		valid = append(valid, hotline)
+	}
+
+	api.hotlines.set(valid)
+
+	return nil
+}
+
+// runHotlinesReload reloads hotlines periodically so that numbers changed in the database are picked without a redeploy
+func (api *ussdAPIServer) runHotlinesReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := api.reloadHotlines()
+		if err != nil {
+			api.logger.Errorf("failed to reload hotlines: %v", err)
+		}
+	}
+}
+
+func (api *ussdAPIServer) responseForHotlines(ussd *ussdPayload, county, lang string) string {
+	var (
+		now      = time.Now()
+		sections = []struct {
+			hotlines []*Hotline
+			title    string
+			max      int
+		}{
+			{
+				hotlines: api.hotlines.find(HotlineCounty, county, lang, now),
+				title:    "hotlines_county_title",
+				max:      3,
+			},
+			{
+				hotlines: api.hotlines.find(HotlineMinistry, "", lang, now),
+				title:    "hotlines_ministry_title",
+				max:      2,
+			},
+			{
+				hotlines: append(api.hotlines.find(HotlineMentalHealth, "", lang, now), api.hotlines.find(HotlineGBV, "", lang, now)...),
+				title:    "hotlines_other_title",
+				max:      2,
+			},
+		}
+		lines = []string{}
+	)
+
+	for _, section := range sections {
+		if len(section.hotlines) == 0 {
+			continue
+		}
+		if len(section.hotlines) > section.max {
+			section.hotlines = section.hotlines[:section.max]
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, ussd.content.Messages.text(section.title, lang))
+		for index, hotline := range section.hotlines {
+			lines = append(lines, fmt.Sprintf("%d. %s %s", index+1, hotline.Label, hotline.Number))
+		}
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, ussd.content.Messages.text("hotlines_none", lang))
+	}
+
+	lines = append(lines, "", ussd.content.Messages.text("hotlines_closing", lang))
+
+	return "END " + strings.Join(lines, "\n")
+}
@@ -0,0 +1,69 @@
+package ussd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHotlineOpenAt(t *testing.T) {
+	at := func(clock string) time.Time {
+		tm, err := time.ParseInLocation("15:04", clock, eastAfricaTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	day := &Hotline{OpensAt: "08:00", ClosesAt: "17:00"}
+	night := &Hotline{OpensAt: "20:00", ClosesAt: "06:00"}
+
+	cases := []struct {
+		hotline *Hotline
+		clock   string
+		open    bool
+	}{
+		{day, "07:59", false},
+		{day, "08:00", true},
+		{day, "16:59", true},
+		{day, "17:00", false},
+		{night, "19:00", false},
+		{night, "23:30", true},
+		{night, "05:59", true},
+		{night, "06:00", false},
+		{&Hotline{}, "03:00", true},
+	}
+	for _, c := range cases {
+		if got := c.hotline.openAt(at(c.clock)); got != c.open {
+			t.Errorf("%s-%s at %s: expected open %v, got %v", c.hotline.OpensAt, c.hotline.ClosesAt, c.clock, c.open, got)
+		}
+	}
+}
+
+func TestLoadHotlines(t *testing.T) {
+	hotlines, err := LoadHotlines(filepath.Join("..", "..", "configs", "hotlines.yml"))
+	if err != nil {
+		t.Fatalf("failed to load hotlines: %v", err)
+	}
+	if len(hotlines) == 0 {
+		t.Fatal("expected hotlines in config")
+	}
+}
+
+func TestHotlineValidateHours(t *testing.T) {
+	for _, c := range []struct {
+		opensAt string
+		valid   bool
+	}{
+		{opensAt: "08:00", valid: true},
+		{opensAt: "8:00"},
+		{opensAt: "08:0"},
+		{opensAt: "24:00"},
+	} {
+		hotline := &Hotline{Category: HotlineMinistry, Label: "Health", Number: "719", OpensAt: c.opensAt, ClosesAt: "17:00"}
+		err := hotline.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.opensAt, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxHouseholdMembers is the most members screened in one session
+	maxHouseholdMembers = 10
+	// modeHousehold is the session mode of household screenings
+	modeHousehold = "household"
+	// householdStepKey is the session key holding the step of the household screening
+	householdStepKey = "household_step"
+	// membersKey is the session key holding the members screened so far as json
+	membersKey = "members"
+	// memberNameKey and relationshipKey hold the member being screened
+	memberNameKey   = "member_name"
+	relationshipKey = "relationship"
+	// Inputs of the screen shown after each member
+	householdAddMember = "1"
+	householdFinish    = "2"
+)
+
+// Steps of the household screening
+const (
+	householdStepName         = "name"
+	householdStepRelationship = "relationship"
+	householdStepScreening    = "screening"
+	householdStepNext         = "next"
+)
+
+// Relationships of household members to the owner of the phone
+const (
+	relationshipSelf    = "self"
+	relationshipSpouse  = "spouse"
+	relationshipChild   = "child"
+	relationshipParent  = "parent"
+	relationshipSibling = "sibling"
+	relationshipOther   = "other"
+)
+
+// householdMember is a member screened in the session. Names are only kept in the session and the SMS summary.
+type householdMember struct {
+	Name         string `json:"name"`
+	Relationship string `json:"relationship"`
+	RiskBand     string `json:"riskBand"`
+}
+
+// householdQuestions are asked before the questionnaire of each member
+func householdQuestions(messages Messages, lang string) (name, relationship, next *Question) {
+	name = &Question{
+		ID:        "member_name",
+		Type:      QuestionText,
+		Text:      map[string]string{lang: messages.text("household_member_name", lang)},
+		MinLength: 1,
+		MaxLength: 20,
+	}
+	relationship = &Question{
+		ID:   "relationship",
+		Text: map[string]string{lang: messages.text("household_relationship", lang)},
+	}
+	for _, value := range []string{
+		relationshipSelf, relationshipSpouse, relationshipChild, relationshipParent, relationshipSibling, relationshipOther,
+	} {
+		relationship.Options = append(relationship.Options, &Option{
+			Text:  map[string]string{lang: messages.text("relationship_"+value, lang)},
+			Value: value,
+		})
+	}
+	next = &Question{
+		ID:   "household_next",
+		Text: map[string]string{lang: messages.text("household_next", lang)},
+		Options: []*Option{
+			{Text: map[string]string{lang: messages.text("household_add_member", lang)}, Value: householdAddMember},
+			{Text: map[string]string{lang: messages.text("household_finish", lang)}, Value: householdFinish},
+		},
+	}
+	return name, relationship, next
+}
+
+// screenedOnBehalf reports whether the person screened in the session is not the owner of the phone
+func screenedOnBehalf(session map[string]string) bool {
+	switch session[modeKey] {
+	case modeHealthWorker:
+		return true
+	case modeHousehold:
+		return session[relationshipKey] != relationshipSelf
+	}
+	return false
+}
+
+// handleHousehold screens several members of a household one after the other. Each member gets the full
+// questionnaire in handleScreening and the step of the household is kept in the session.
+func (api *ussdAPIServer) handleHousehold(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages                 = ussd.content.Messages
+		name, relationship, next = householdQuestions(messages, lang)
+		qc                       = &questionContext{messages: messages}
+	)
+
+	// Start of household screening
+	if strings.Count(ussd.Text, "*") == 1 {
+		w.stage = "household_start"
+		err := api.sessions.SetAll(ussd.SessionID, map[string]string{
+			modeKey:          modeHousehold,
+			householdStepKey: householdStepName,
+			membersKey:       "[]",
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to start household screening")
+		}
+		return ussd.content.Questionnaire.render(name, qc, lang, messages.text("household_intro", lang), false), nil
+	}
+
+	session, err := api.getUserFromSession(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user session")
+	}
+
+	input := ussd.Text[strings.LastIndex(ussd.Text, "*")+1:]
+
+	switch session[householdStepKey] {
+	case householdStepName:
+		ans, invalid := name.parse(qc, input)
+		if invalid != nil {
+			w.stage = "household_name_invalid"
+			return ussd.content.Questionnaire.render(name, qc, lang, invalid.text(messages, lang), false), nil
+		}
+		err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+			memberNameKey:    ans.values[0],
+			householdStepKey: householdStepRelationship,
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to save member name")
+		}
+		w.stage = "household_relationship"
+		return ussd.content.Questionnaire.render(relationship, qc, lang, "", false), nil
+
+	case householdStepRelationship:
+		ans, invalid := relationship.parse(qc, input)
+		if invalid != nil {
+			w.stage = "household_relationship_invalid"
+			return ussd.content.Questionnaire.render(relationship, qc, lang, invalid.text(messages, lang), false), nil
+		}
+		w.stage = "household_screening_start"
+		return api.startMemberScreening(ussd, session, ans.values[0], lang)
+
+	case householdStepScreening:
+		return api.handleScreening(ctx, w, ussd)
+
+	case householdStepNext:
+		ans, invalid := next.parse(qc, input)
+		if invalid != nil {
+			w.stage = "household_next_invalid"
+			return ussd.content.Questionnaire.render(next, qc, lang, invalid.text(messages, lang), false), nil
+		}
+		if ans.values[0] == householdFinish {
+			w.stage = "household_finish"
+			return api.finishHousehold(ctx, ussd, session, lang)
+		}
+
+		members, err := sessionMembers(session)
+		if err != nil {
+			return "", err
+		}
+		if len(members) >= maxHouseholdMembers {
+			w.stage = "household_limit"
+			notice := fmt.Sprintf(messages.text("household_limit", lang), maxHouseholdMembers)
+			return ussd.content.Questionnaire.render(next, qc, lang, notice, false), nil
+		}
+
+		err = api.sessions.Set(ussd.SessionID, householdStepKey, householdStepName)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to add household member")
+		}
+		w.stage = "household_name"
+		return ussd.content.Questionnaire.render(name, qc, lang, "", false), nil
+	}
+
+	return "", errors.Errorf("unknown household step %q", session[householdStepKey])
+}
+
+// startMemberScreening clears the answers of the previous member and starts the questionnaire
+func (api *ussdAPIServer) startMemberScreening(ussd *ussdPayload, session map[string]string, relationship, lang string) (string, error) {
+	keys := []string{scoreKey, pageKey}
+	for key := range session {
+		if strings.HasPrefix(key, answerKeyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	err := api.sessions.DeleteKeys(ussd.SessionID, keys...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to clear previous member")
+	}
+
+	questionnaire := ussd.content.Questionnaire
+	first := questionnaire.Questions[0]
+	err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+		relationshipKey:  relationship,
+		householdStepKey: householdStepScreening,
+		questionKey:      first.ID,
+		questionnaireKey: questionnaire.Version,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start member screening")
+	}
+
+	return questionnaire.render(first, api.questionContext(ussd, nil), lang, "", true), nil
+}
+
+// memberScreened adds the result of the member to the household and asks whether to screen another member
+func (api *ussdAPIServer) memberScreened(ussd *ussdPayload, session map[string]string, band, lang string) (string, error) {
+	members, err := sessionMembers(session)
+	if err != nil {
+		return "", err
+	}
+	members = append(members, &householdMember{
+		Name:         session[memberNameKey],
+		Relationship: session[relationshipKey],
+		RiskBand:     band,
+	})
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode household members")
+	}
+
+	err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+		membersKey:       string(data),
+		householdStepKey: householdStepNext,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save household member")
+	}
+
+	messages := ussd.content.Messages
+	_, _, next := householdQuestions(messages, lang)
+	notice := fmt.Sprintf(messages.text("household_member_result", lang), session[memberNameKey], messages.text("risk_"+band, lang))
+	return ussd.content.Questionnaire.render(next, &questionContext{messages: messages}, lang, notice, false), nil
+}
+
+// finishHousehold sends the risk band of every member by SMS
+func (api *ussdAPIServer) finishHousehold(ctx context.Context, ussd *ussdPayload, session map[string]string, lang string) (string, error) {
+	members, err := sessionMembers(session)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		messages = ussd.content.Messages
+		lines    = []string{messages.text("household_sms_title", lang)}
+		high     = false
+	)
+	for index, member := range members {
+		lines = append(lines, fmt.Sprintf("%d. %s (%s): %s", index+1, member.Name,
+			messages.text("relationship_"+member.Relationship, lang), messages.text("risk_"+member.RiskBand, lang)))
+		high = high || member.RiskBand == riskHigh
+	}
+	if high {
+		lines = append(lines, messages.text("risk_find_facility", lang))
+	}
+
+	err = api.queueSMS(ctx, ussd.PhoneNumber, strings.Join(lines, "\n"), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send household summary")
+	}
+	householdScreeningsTotal.WithLabelValues(fmt.Sprint(len(members))).Inc()
+
+	return "END " + fmt.Sprintf(messages.text("household_sms_sent", lang), len(members)), nil
+}
+
+func sessionMembers(session map[string]string) ([]*householdMember, error) {
+	members := make([]*householdMember, 0)
+	if session[membersKey] == "" {
+		return members, nil
+	}
+	err := json.Unmarshal([]byte(session[membersKey]), &members)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode household members")
+	}
+	return members, nil
+}
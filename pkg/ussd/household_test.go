@@ -0,0 +1,111 @@
+package ussd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHouseholdScreening(t *testing.T) {
+	api := newTestAPI(t)
+
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_household.golden"))
+	replay(t, api, "ATUid_household", exchanges)
+
+	sms := api.sms.(*fakeSMS)
+	if len(sms.messages) != 1 {
+		t.Fatalf("expected 1 summary SMS, got %d", len(sms.messages))
+	}
+	for _, line := range []string{"1. Mama J (Myself): HIGH", "2. Baby (Child): HIGH", "Find a testing centre"} {
+		if !strings.Contains(sms.messages[0], line) {
+			t.Errorf("expected summary to contain %q, got %q", line, sms.messages[0])
+		}
+	}
+
+	// Each member is a screening with its own answers and only the owner of the phone is linked to it
+	screenings := make([]*screening, 0)
+	err := api.sqlDB.Preload("Answers").Order("id").Find(&screenings, "session_id = ?", "ATUid_household").Error
+	if err != nil {
+		t.Fatalf("failed to get screenings: %v", err)
+	}
+	if len(screenings) != 2 {
+		t.Fatalf("expected 2 screenings, got %d", len(screenings))
+	}
+	if screenings[0].Relationship != relationshipSelf || screenings[0].PhoneHash != api.hashPhone(testPhone) {
+		t.Errorf("unexpected first member screening %+v", screenings[0])
+	}
+	if screenings[1].Relationship != relationshipChild || screenings[1].PhoneHash != "" {
+		t.Errorf("unexpected second member screening %+v", screenings[1])
+	}
+	if len(screenings[0].Answers) == 0 || len(screenings[0].Answers) != len(screenings[1].Answers) {
+		t.Errorf("expected separate answers for each member, got %d and %d", len(screenings[0].Answers), len(screenings[1].Answers))
+	}
+	if screenings[0].RiskScore != screenings[1].RiskScore {
+		t.Errorf("expected the score to restart for each member, got %d and %d", screenings[0].RiskScore, screenings[1].RiskScore)
+	}
+}
+
+func TestFullHousehold(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		var err error
+		opt.RateLimits.PerPhone, err = ParseRateLimit(DefaultPhoneRateLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Every member gets the answers of the household conversation
+	answers := []string{"65", "30", "5", "1", "2,4", "1,2,4", "3", "2", "38.5", "2,4"}
+	exchanges := []exchange{{input: "*384#"}, {input: "1"}, {input: "1"}, {input: "9"}}
+	for i := 1; i <= maxHouseholdMembers; i++ {
+		relationship := "3"
+		if i == 1 {
+			relationship = "1"
+		}
+		exchanges = append(exchanges, exchange{input: fmt.Sprintf("Member %d", i)}, exchange{input: relationship})
+		for _, answer := range answers {
+			exchanges = append(exchanges, exchange{input: answer})
+		}
+		exchanges = append(exchanges, exchange{input: householdAddMember})
+	}
+	exchanges = append(exchanges, exchange{input: householdFinish})
+
+	got := replay(t, api, "ATUid_full_household", exchanges)
+	for _, ex := range got {
+		if strings.Contains(ex.screen, "too many requests") || strings.HasPrefix(ex.screen, "[status") {
+			t.Fatalf("session cut short after %d inputs: %q", len(got), ex.screen)
+		}
+	}
+	if screen := got[len(got)-2].screen; !strings.HasPrefix(screen, "CON You can screen up to 10 members") {
+		t.Errorf("expected member limit, got %q", screen)
+	}
+	if screen := got[len(got)-1].screen; screen != "END You screened 10 members. We have sent you the results by SMS" {
+		t.Errorf("expected summary to be sent, got %q", screen)
+	}
+
+	var screenings int
+	api.sqlDB.Model(&screening{}).Where("session_id = ?", "ATUid_full_household").Count(&screenings)
+	if screenings != maxHouseholdMembers {
+		t.Errorf("expected %d screenings, got %d", maxHouseholdMembers, screenings)
+	}
+}
+
+func TestHouseholdScreeningDuringIsolation(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Unlike self-screening, the household screening of the owner of the phone goes ahead during isolation
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_household.golden"))
+	replay(t, api, "ATUid_household", exchanges)
+
+	owner := &screening{}
+	err := api.sqlDB.First(owner, "session_id = ? AND relationship = ?", "ATUid_household", relationshipSelf).Error
+	if err != nil {
+		t.Fatalf("failed to get screening: %v", err)
+	}
+	if !owner.Isolating || owner.RiskBand != riskHigh {
+		t.Errorf("expected the owner to be screened as isolating and high risk, got %+v", owner)
+	}
+}
@@ -0,0 +1,178 @@
+package ussd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// County is a county and its sub-counties as shown in location menus
+type County struct {
+	Name        string   `yaml:"name"`
+	SubCounties []string `yaml:"subCounties"`
+}
+
+// validateLocations checks that counties and their sub-counties can be listed in menus
+func validateLocations(counties []*County) error {
+	switch {
+	case len(counties) == 0:
+		return errors.New("no counties")
+	case len(counties) >= pageMore:
+		return errors.Errorf("at most %d counties can be listed", pageMore-1)
+	}
+
+	names := make(map[string]bool, len(counties))
+	for _, county := range counties {
+		key := strings.ToLower(county.Name)
+		switch {
+		case county.Name == "":
+			return errors.New("county has no name")
+		case names[key]:
+			return errors.Errorf("county %q is listed more than once", county.Name)
+		case len(county.SubCounties) == 0:
+			return errors.Errorf("county %q has no sub-counties", county.Name)
+		case len(county.SubCounties) >= pageMore:
+			return errors.Errorf("county %q has more than %d sub-counties", county.Name, pageMore-1)
+		}
+		names[key] = true
+	}
+
+	return nil
+}
+
+func findCounty(counties []*County, name string) *County {
+	for _, county := range counties {
+		if strings.EqualFold(county.Name, name) {
+			return county
+		}
+	}
+	return nil
+}
+
+// countyCases is the official number of confirmed COVID-19 cases in a county on a date
+type countyCases struct {
+	ID     uint      `gorm:"primary_key"`
+	County string    `gorm:"type:varchar(50);unique_index:idx_county_date;not null"`
+	Date   time.Time `gorm:"type:date;unique_index:idx_county_date;not null"`
+	// Cases is the cumulative number of confirmed cases
+	Cases     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (*countyCases) TableName() string {
+	return "ussd_county_cases"
+}
+
+// latestCountyCases returns the most recent count of every county
+func latestCountyCases(db *gorm.DB) ([]*countyCases, error) {
+	rows := make([]*countyCases, 0)
+	err := db.Raw(
+		"SELECT county, date, cases FROM ussd_county_cases c WHERE date = (SELECT MAX(date) FROM ussd_county_cases WHERE county = c.county) ORDER BY county",
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get county cases")
+	}
+	return rows, nil
+}
+
+const (
+	// newCasesDays is the number of days over which new cases score the risk of a county
+	newCasesDays = 14
+	// staleCasesDays is the age in days after which the figures of a county are too old to score its risk
+	staleCasesDays = 7
+)
+
+// recentCaseCounts returns the number of new cases of every county in the last newCasesDays days keyed by lower case
+// county name. Counties whose latest figures are older than staleCasesDays or that have no figures from before the
+// last newCasesDays days are left out as unknown.
+func (api *ussdAPIServer) recentCaseCounts(now time.Time) (map[string]int, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	rows := make([]*countyCases, 0)
+	err := api.sqlDB.Order("county, date DESC").
+		Find(&rows, "date >= ?", today.AddDate(0, 0, -newCasesDays-staleCasesDays)).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get county cases")
+	}
+
+	var (
+		counts = make(map[string]int, len(rows))
+		latest = make(map[string]*countyCases, len(rows))
+	)
+	for _, row := range rows {
+		key := strings.ToLower(row.County)
+		last, ok := latest[key]
+		switch {
+		case !ok:
+			// Rows are ordered by date so the first of a county is its latest
+			if row.Date.Before(today.AddDate(0, 0, -staleCasesDays)) {
+				latest[key] = nil
+				continue
+			}
+			latest[key] = row
+		case last == nil:
+		case !row.Date.After(today.AddDate(0, 0, -newCasesDays)):
+			if _, counted := counts[key]; !counted {
+				counts[key] = last.Cases - row.Cases
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+// DefaultLocations returns the counties of Kenya in alphabetical order. Sub-counties follow constituency boundaries.
+func DefaultLocations() []*County {
+	return []*County{
+		{Name: "Baringo", SubCounties: []string{"Tiaty", "Baringo North", "Baringo Central", "Baringo South", "Mogotio", "Eldama Ravine"}},
+		{Name: "Bomet", SubCounties: []string{"Sotik", "Chepalungu", "Bomet East", "Bomet Central", "Konoin"}},
+		{Name: "Bungoma", SubCounties: []string{"Mt. Elgon", "Sirisia", "Kabuchai", "Bumula", "Kanduyi", "Webuye East", "Webuye West", "Kimilili", "Tongaren"}},
+		{Name: "Busia", SubCounties: []string{"Teso North", "Teso South", "Nambale", "Matayos", "Butula", "Funyula", "Budalangi"}},
+		{Name: "Elgeyo Marakwet", SubCounties: []string{"Marakwet East", "Marakwet West", "Keiyo North", "Keiyo South"}},
+		{Name: "Embu", SubCounties: []string{"Manyatta", "Runyenjes", "Mbeere South", "Mbeere North"}},
+		{Name: "Garissa", SubCounties: []string{"Garissa Township", "Balambala", "Lagdera", "Dadaab", "Fafi", "Ijara"}},
+		{Name: "Homa Bay", SubCounties: []string{"Kasipul", "Kabondo Kasipul", "Karachuonyo", "Rangwe", "Homa Bay Town", "Ndhiwa", "Suba North", "Suba South"}},
+		{Name: "Isiolo", SubCounties: []string{"Isiolo North", "Isiolo South"}},
+		{Name: "Kajiado", SubCounties: []string{"Kajiado North", "Kajiado Central", "Kajiado East", "Kajiado West", "Kajiado South"}},
+		{Name: "Kakamega", SubCounties: []string{"Lugari", "Likuyani", "Malava", "Lurambi", "Navakholo", "Mumias West", "Mumias East", "Matungu", "Butere", "Khwisero", "Shinyalu", "Ikolomani"}},
+		{Name: "Kericho", SubCounties: []string{"Kipkelion East", "Kipkelion West", "Ainamoi", "Bureti", "Belgut", "Sigowet/Soin"}},
+		{Name: "Kiambu", SubCounties: []string{"Gatundu South", "Gatundu North", "Juja", "Thika Town", "Ruiru", "Githunguri", "Kiambu", "Kiambaa", "Kabete", "Kikuyu", "Limuru", "Lari"}},
+		{Name: "Kilifi", SubCounties: []string{"Kilifi North", "Kilifi South", "Kaloleni", "Rabai", "Ganze", "Malindi", "Magarini"}},
+		{Name: "Kirinyaga", SubCounties: []string{"Mwea", "Gichugu", "Ndia", "Kirinyaga Central"}},
+		{Name: "Kisii", SubCounties: []string{"Bonchari", "South Mugirango", "Bomachoge Borabu", "Bobasi", "Bomachoge Chache", "Nyaribari Masaba", "Nyaribari Chache", "Kitutu Chache North", "Kitutu Chache South"}},
+		{Name: "Kisumu", SubCounties: []string{"Kisumu East", "Kisumu West", "Kisumu Central", "Seme", "Nyando", "Muhoroni", "Nyakach"}},
+		{Name: "Kitui", SubCounties: []string{"Mwingi North", "Mwingi West", "Mwingi Central", "Kitui West", "Kitui Rural", "Kitui Central", "Kitui East", "Kitui South"}},
+		{Name: "Kwale", SubCounties: []string{"Msambweni", "Lunga Lunga", "Matuga", "Kinango"}},
+		{Name: "Laikipia", SubCounties: []string{"Laikipia West", "Laikipia East", "Laikipia North"}},
+		{Name: "Lamu", SubCounties: []string{"Lamu East", "Lamu West"}},
+		{Name: "Machakos", SubCounties: []string{"Masinga", "Yatta", "Kangundo", "Matungulu", "Kathiani", "Mavoko", "Machakos Town", "Mwala"}},
+		{Name: "Makueni", SubCounties: []string{"Mbooni", "Kilome", "Kaiti", "Makueni", "Kibwezi West", "Kibwezi East"}},
+		{Name: "Mandera", SubCounties: []string{"Mandera West", "Banissa", "Mandera North", "Mandera South", "Mandera East", "Lafey"}},
+		{Name: "Marsabit", SubCounties: []string{"Moyale", "North Horr", "Saku", "Laisamis"}},
+		{Name: "Meru", SubCounties: []string{"Igembe South", "Igembe Central", "Igembe North", "Tigania West", "Tigania East", "North Imenti", "Buuri", "Central Imenti", "South Imenti"}},
+		{Name: "Migori", SubCounties: []string{"Rongo", "Awendo", "Suna East", "Suna West", "Uriri", "Nyatike", "Kuria West", "Kuria East"}},
+		{Name: "Mombasa", SubCounties: []string{"Changamwe", "Jomvu", "Kisauni", "Nyali", "Likoni", "Mvita"}},
+		{Name: "Murang'a", SubCounties: []string{"Kangema", "Mathioya", "Kiharu", "Kigumo", "Maragwa", "Kandara", "Gatanga"}},
+		{Name: "Nairobi", SubCounties: []string{"Westlands", "Dagoretti North", "Dagoretti South", "Langata", "Kibra", "Roysambu", "Kasarani", "Ruaraka", "Embakasi South", "Embakasi North", "Embakasi Central", "Embakasi East", "Embakasi West", "Makadara", "Kamukunji", "Starehe", "Mathare"}},
+		{Name: "Nakuru", SubCounties: []string{"Molo", "Njoro", "Naivasha", "Gilgil", "Kuresoi South", "Kuresoi North", "Subukia", "Rongai", "Bahati", "Nakuru Town West", "Nakuru Town East"}},
+		{Name: "Nandi", SubCounties: []string{"Tinderet", "Aldai", "Nandi Hills", "Chesumei", "Emgwen", "Mosop"}},
+		{Name: "Narok", SubCounties: []string{"Kilgoris", "Emurua Dikirr", "Narok North", "Narok East", "Narok South", "Narok West"}},
+		{Name: "Nyamira", SubCounties: []string{"Kitutu Masaba", "West Mugirango", "North Mugirango", "Borabu"}},
+		{Name: "Nyandarua", SubCounties: []string{"Kinangop", "Kipipiri", "Ol Kalou", "Ol Jorok", "Ndaragwa"}},
+		{Name: "Nyeri", SubCounties: []string{"Tetu", "Kieni", "Mathira", "Othaya", "Mukurweini", "Nyeri Town"}},
+		{Name: "Samburu", SubCounties: []string{"Samburu West", "Samburu North", "Samburu East"}},
+		{Name: "Siaya", SubCounties: []string{"Ugenya", "Ugunja", "Alego Usonga", "Gem", "Bondo", "Rarieda"}},
+		{Name: "Taita Taveta", SubCounties: []string{"Taveta", "Wundanyi", "Mwatate", "Voi"}},
+		{Name: "Tana River", SubCounties: []string{"Garsen", "Galole", "Bura"}},
+		{Name: "Tharaka Nithi", SubCounties: []string{"Maara", "Chuka/Igambang'ombe", "Tharaka"}},
+		{Name: "Trans Nzoia", SubCounties: []string{"Kwanza", "Endebess", "Saboti", "Kiminini", "Cherangany"}},
+		{Name: "Turkana", SubCounties: []string{"Turkana North", "Turkana West", "Turkana Central", "Loima", "Turkana South", "Turkana East"}},
+		{Name: "Uasin Gishu", SubCounties: []string{"Soy", "Turbo", "Moiben", "Ainabkoi", "Kapseret", "Kesses"}},
+		{Name: "Vihiga", SubCounties: []string{"Vihiga", "Sabatia", "Hamisi", "Luanda", "Emuhaya"}},
+		{Name: "Wajir", SubCounties: []string{"Wajir North", "Wajir East", "Tarbaj", "Wajir West", "Eldas", "Wajir South"}},
+		{Name: "West Pokot", SubCounties: []string{"Kapenguria", "Sigor", "Kacheliba", "Pokot South"}},
+	}
+}
@@ -0,0 +1,85 @@
+package ussd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountyScoreUsesRecentCases(t *testing.T) {
+	api := newTestAPI(t)
+
+	now := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	day := func(days int) time.Time {
+		return time.Date(2020, 6, 1-days, 0, 0, 0, 0, time.UTC)
+	}
+	for _, row := range []*countyCases{
+		// 150 new cases in the last 14 days
+		{County: "Nairobi", Date: day(20), Cases: 900},
+		{County: "Nairobi", Date: day(14), Cases: 1000},
+		{County: "Nairobi", Date: day(1), Cases: 1150},
+		// Many cases but only 20 of them new
+		{County: "Mombasa", Date: day(16), Cases: 980},
+		{County: "Mombasa", Date: day(2), Cases: 1000},
+		// The latest figures are too old
+		{County: "Kisumu", Date: day(30), Cases: 100},
+		{County: "Kisumu", Date: day(10), Cases: 500},
+		// No figures from two weeks before the latest
+		{County: "Nakuru", Date: day(3), Cases: 50},
+		{County: "Nakuru", Date: day(1), Cases: 500},
+	} {
+		err := api.sqlDB.Create(row).Error
+		if err != nil {
+			t.Fatalf("failed to save county cases: %v", err)
+		}
+	}
+
+	cases, err := api.recentCaseCounts(now)
+	if err != nil {
+		t.Fatalf("failed to get case counts: %v", err)
+	}
+	if len(cases) != 2 || cases["nairobi"] != 150 || cases["mombasa"] != 20 {
+		t.Fatalf("expected new cases of Nairobi and Mombasa only, got %v", cases)
+	}
+
+	county, _ := api.content.current().Questionnaire.question("county")
+	qc := &questionContext{locations: DefaultLocations(), cases: cases}
+	for _, c := range []struct {
+		input string
+		score int
+	}{
+		{input: "30", score: 2}, // Nairobi
+		{input: "28", score: 1}, // Mombasa
+		{input: "17", score: 0}, // Kisumu figures are stale
+		{input: "1", score: 0},  // Baringo has no data
+	} {
+		ans, invalid := county.parse(qc, c.input)
+		if invalid != nil {
+			t.Fatalf("expected %q to be valid, got %s", c.input, invalid.message)
+		}
+		if ans.score != c.score {
+			t.Errorf("expected score %d for %s, got %d", c.score, ans.values[0], ans.score)
+		}
+	}
+}
+
+func TestSubCountyOptionsFollowCounty(t *testing.T) {
+	questionnaire := DefaultQuestionnaire()
+	subCounty, _ := questionnaire.question("sub_county")
+
+	qc := &questionContext{
+		answers:   map[string][]string{"county": {"Mombasa"}},
+		locations: DefaultLocations(),
+	}
+	ans, invalid := subCounty.parse(qc, "6")
+	if invalid != nil {
+		t.Fatalf("expected sub-county to be valid, got %s", invalid.message)
+	}
+	if ans.values[0] != "Mvita" {
+		t.Errorf("expected Mvita, got %s", ans.values[0])
+	}
+
+	_, invalid = subCounty.parse(qc, "7")
+	if invalid == nil {
+		t.Error("expected option outside the county sub-counties to be invalid")
+	}
+}
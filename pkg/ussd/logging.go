@@ -0,0 +1,165 @@
+package ussd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// requestLog records what happened in a single USSD request so that it can be logged as one structured entry
+type requestLog struct {
+	http.ResponseWriter
+	start    time.Time
+	ussd     *ussdPayload
+	stage    string
+	status   int
+	response string
+	errMsg   string
+	err      error
+	// timings are the redis and database latencies of the request
+	timings *requestTimings
+	// timedOut is set when the user got the busy screen because the request took too long
+	timedOut bool
+}
+
+func (rl *requestLog) WriteHeader(statusCode int) {
+	rl.status = statusCode
+	rl.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rl *requestLog) Write(data []byte) (int, error) {
+	if rl.response == "" {
+		rl.response = string(data)
+	}
+	return rl.ResponseWriter.Write(data)
+}
+
+func (rl *requestLog) outcome() string {
+	switch {
+	case rl.timedOut:
+		return "timeout"
+	case rl.status >= http.StatusBadRequest:
+		return "error"
+	case strings.HasPrefix(rl.response, "CON"):
+		return "continue"
+	case strings.HasPrefix(rl.response, "END"):
+		return "end"
+	default:
+		return "empty"
+	}
+}
+
+func (api *ussdAPIServer) logRequest(rl *requestLog) {
+	latency := time.Since(rl.start)
+	outcome := rl.outcome()
+
+	requestDuration.WithLabelValues(rl.stage).Observe(latency.Seconds())
+	switch outcome {
+	case "timeout":
+		requestTimeoutsTotal.WithLabelValues(rl.stage).Inc()
+	case "error":
+		errorsTotal.WithLabelValues(rl.stage).Inc()
+	default:
+		stepsTotal.WithLabelValues(rl.stage).Inc()
+	}
+
+	fields := logrus.Fields{
+		"latency_ms": latency.Seconds() * 1000,
+		"status":     rl.status,
+		"stage":      rl.stage,
+		"outcome":    outcome,
+	}
+	if rl.timings != nil {
+		for key, value := range rl.timings.fields() {
+			fields[key] = value
+		}
+	}
+
+	if rl.ussd != nil {
+		fields["session_id"] = rl.ussd.SessionID
+		fields["phone_hash"] = api.hashPhone(rl.ussd.PhoneNumber)
+		fields["network_code"] = rl.ussd.NetworkCode
+		fields["service_code"] = rl.ussd.ServiceCode
+		fields["step"] = ussdStep(rl.ussd.Text)
+		fields["text"] = redactText(rl.ussd.rawText, api.serviceInput())
+	}
+
+	entry := api.requestLogger.WithFields(fields)
+
+	if rl.errMsg != "" {
+		entry = entry.WithField("error", rl.errMsg)
+	}
+
+	if rl.err != nil {
+		entry.WithFields(logrus.Fields{
+			"error_cause":   errors.Cause(rl.err).Error(),
+			"error_details": rl.err.Error(),
+		}).Error("ussd request failed")
+		return
+	}
+
+	if outcome == "error" {
+		entry.Error("ussd request failed")
+		return
+	}
+
+	if outcome == "timeout" {
+		entry.Warning("ussd request timed out")
+		return
+	}
+
+	entry.Info("ussd request")
+}
+
+// ussdStep is the number of inputs the user has entered in the session
+func ussdStep(text string) int {
+	if text == "" {
+		return 0
+	}
+	return strings.Count(text, "*") + 1
+}
+
+// serviceInput is the index of the service selection in the text sent by the gateway
+func (api *ussdAPIServer) serviceInput() int {
+	if api.consent.Required {
+		return 2
+	}
+	return 1
+}
+
+// redactText masks free text inputs (e.g names) while keeping menu selections. service is the index of the
+// service selection in the text.
+func redactText(text string, service int) string {
+	if text == "" {
+		return ""
+	}
+
+	inputs := strings.Split(text, "*")
+	// The service follows the inputs that page through the services menu
+	for service < len(inputs)-1 && (inputs[service] == strconv.Itoa(pageMore) || inputs[service] == pageBack) {
+		service++
+	}
+
+	// Inputs of the exposure menu are phone numbers of contacts and those of the health worker menu include the PIN
+	if len(inputs) > service+1 && (inputs[service] == "7" || inputs[service] == "8") {
+		for i := service + 1; i < len(inputs); i++ {
+			inputs[i] = "<redacted>"
+		}
+	}
+
+	for i, input := range inputs {
+		for _, r := range input {
+			if !unicode.IsDigit(r) && r != ',' && r != ' ' {
+				inputs[i] = "<redacted>"
+				break
+			}
+		}
+	}
+
+	return strings.Join(inputs, "*")
+}
@@ -0,0 +1,25 @@
+package ussd
+
+import "testing"
+
+func TestRedactText(t *testing.T) {
+	for _, c := range []struct {
+		text    string
+		service int
+		want    string
+	}{
+		{text: "", service: 1, want: ""},
+		{text: "1*1*2*1", service: 1, want: "1*1*2*1"},
+		{text: "1*1*4*Jane", service: 1, want: "1*1*4*<redacted>"},
+		{text: "1*7*1*0712000001*2", service: 1, want: "1*7*<redacted>*<redacted>*<redacted>"},
+		{text: "1*1*7*1*0712000001", service: 2, want: "1*1*7*<redacted>*<redacted>"},
+		{text: "1*1*8*1234*1", service: 2, want: "1*1*8*<redacted>*<redacted>"},
+		{text: "1*1*98*98*0*7*1*0712000001", service: 2, want: "1*1*98*98*0*7*<redacted>*<redacted>"},
+		// The consent input is not a service
+		{text: "1*7*1*0712000001", service: 2, want: "1*7*1*0712000001"},
+	} {
+		if got := redactText(c.text, c.service); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.text, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package ussd
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Messages is the catalog of screen texts keyed by message id and language code. Texts don't include the
+// CON/END prefix.
+type Messages map[string]map[string]string
+
+// text returns the message in the language, falling back to English
+func (messages Messages) text(id, lang string) string {
+	texts := messages[id]
+	if text, ok := texts[lang]; ok {
+		return text
+	}
+	return texts[eng]
+}
+
+// list returns a message that holds one item per line
+func (messages Messages) list(id, lang string) []string {
+	return strings.Split(messages.text(id, lang), "\n")
+}
+
+// merge returns the default messages overridden by the given messages
+func (messages Messages) merge(defaults Messages) Messages {
+	merged := make(Messages, len(defaults))
+	for id, texts := range defaults {
+		merged[id] = make(map[string]string, len(texts))
+		for lang, text := range texts {
+			merged[id][lang] = text
+		}
+	}
+	for id, texts := range messages {
+		if merged[id] == nil {
+			merged[id] = make(map[string]string, len(texts))
+		}
+		for lang, text := range texts {
+			merged[id][lang] = text
+		}
+	}
+	return merged
+}
+
+// Validate checks that every default message has an English text and the same placeholders as the default
+func (messages Messages) Validate() error {
+	for id, defaults := range DefaultMessages() {
+		if messages[id][eng] == "" {
+			return errors.Errorf("message %q has no English text", id)
+		}
+		for lang, text := range messages[id] {
+			if strings.Count(text, "%") != strings.Count(defaults[eng], "%") {
+				return errors.Errorf("message %q in %q must have the same placeholders as %q", id, lang, defaults[eng])
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultMessages returns the built-in screen texts
+func DefaultMessages() Messages {
+	return Messages{
+		"welcome": {
+			eng: "Welcome to KoviTrace. Select language \n1. English \n2. Kiswahili",
+		},
+		"services": translations("Select service you want to access.", "Changua huduma unachotaka kupata."),
+		"services_list": translations(
+			"Self-Screening for COVID-19\nView local hotlines\nCOVID-19 stats in my county\nCOVID-19 facts and myths\n"+
+				"Find a testing centre\nReport my test result\nNotify my close contacts\nHealth worker\nScreen my household",
+			"Kujichunguza dhidi ya COVID-19\nTazama nambari za eneo\nTakwimu za COVID-19 katika kaunti yangu\n"+
+				"Ukweli na uongo kuhusu COVID-19\nTafuta kituo cha kupimwa\nRipoti matokeo ya kipimo changu\n"+
+				"Arifu watu niliokaribiana nao\nMhudumu wa afya\nChunguza familia yangu",
+		),
+		"consent": translations(
+			"KoviTrace uses your answers to monitor COVID-19. Your phone number is kept private.\n"+
+				"1. Accept\n2. Decline\n3. Read more via SMS",
+			"KoviTrace hutumia majibu yako kufuatilia COVID-19. Nambari yako ya simu inawekwa siri.\n"+
+				"1. Kubali\n2. Kataa\n3. Soma zaidi kupitia SMS",
+		),
+		"consent_details": translations(
+			"KoviTrace privacy notice (%s): Your screening answers are used by health authorities "+
+				"to monitor COVID-19. Your phone number is stored separately and only used to send you health follow-ups. "+
+				"Identifiable data is deleted after the retention period. If you decline, your answers are not saved.",
+			"Ilani ya faragha ya KoviTrace (%s): Majibu yako hutumiwa na mamlaka ya afya "+
+				"kufuatilia COVID-19. Nambari yako ya simu huhifadhiwa kando na hutumika tu kukutumia ujumbe wa afya. "+
+				"Data inayokutambulisha hufutwa baada ya muda wa kuhifadhi. Ukikataa, majibu yako hayatahifadhiwa.",
+		),
+		"consent_sms_sent": translations(
+			"We have sent you more details by SMS. Dial again to continue.",
+			"Tumekutumia maelezo zaidi kwa SMS. Piga tena kuendelea.",
+		),
+		"invalid_choice": translations(
+			"Invalid choice. Dial again to continue.",
+			"Chaguo si sahihi. Piga tena kuendelea.",
+		),
+		"invalid_option": translations(
+			"Choose a number from 1 to %d",
+			"Chagua nambari kati ya 1 na %d",
+		),
+		"invalid_yes_no": translations(
+			"Choose 1 for Yes or 2 for No",
+			"Chagua 1 kwa Ndio au 2 kwa Hapana",
+		),
+		"invalid_integer": translations(
+			"Enter a whole number from %s to %s",
+			"Andika nambari kamili kati ya %s na %s",
+		),
+		"invalid_number": translations(
+			"Enter a number from %s to %s",
+			"Andika nambari kati ya %s na %s",
+		),
+		"invalid_text": translations(
+			"Enter %d to %d characters",
+			"Andika herufi %d hadi %d",
+		),
+		"invalid_phone": translations(
+			"Enter a mobile number like 0712345678",
+			"Andika nambari ya simu kama 0712345678",
+		),
+		"page_more": translations("More", "Zaidi"),
+		"page_back": translations("Back", "Rudi"),
+		"questionnaire_changed": translations(
+			"The questionnaire has been updated. Dial again to start over.",
+			"Maswali yamebadilishwa. Piga tena kuanza upya.",
+		),
+		"hotlines_county":         translations("Type county name", "Andika jina la kaunti"),
+		"hotlines_county_title":   translations("County hotlines", "Nambari za kaunti"),
+		"hotlines_ministry_title": translations("Ministry hotlines", "Nambari za wizara"),
+		"hotlines_other_title":    translations("Other hotlines", "Nambari zingine"),
+		"hotlines_none":           translations("No hotlines available now", "Hakuna nambari kwa sasa"),
+		"hotlines_closing":        translations("Keep using KoviTrace. Keep safe", "Endelea kutumia KoviTrace. Jizuie"),
+		"stats_county":            translations("Select your county", "Chagua kaunti yako"),
+		"stats_result": translations(
+			"%s COVID-19 cases\nTotal confirmed: %d as of %s",
+			"Kesi za COVID-19 %s\nJumla zilizothibitishwa: %d kufikia %s",
+		),
+		"stats_new": translations("New in the last 7 days: %d", "Mpya katika siku 7 zilizopita: %d"),
+		"stats_none": translations(
+			"There are no official COVID-19 figures for %s yet",
+			"Bado hakuna takwimu rasmi za COVID-19 za %s",
+		),
+		"articles_title": translations("Select a topic", "Chagua mada"),
+		"article_sms":    translations("Get full article by SMS", "Pata makala kamili kwa SMS"),
+		"article_sms_sent": translations(
+			"We have sent you the article by SMS. Keep safe",
+			"Tumekutumia makala kwa SMS. Jizuie",
+		),
+		"facilities_county":     translations("Select your county", "Chagua kaunti yako"),
+		"facilities_sub_county": translations("Select your sub-county", "Chagua kaunti ndogo yako"),
+		"facilities_title":      translations("Facilities near %s", "Vituo karibu na %s"),
+		"facilities_none": translations(
+			"No testing centres are listed for %s yet. Dial again and choose View local hotlines",
+			"Bado hakuna vituo vya kupimwa vya %s. Piga tena uchague Tazama nambari za eneo",
+		),
+		"facilities_sms":       translations("SMS me the list", "Nitumie orodha kwa SMS"),
+		"facilities_sms_title": translations("Health facilities near %s:", "Vituo vya afya karibu na %s:"),
+		"facilities_sms_sent": translations(
+			"We have sent you the list by SMS. Keep safe",
+			"Tumekutumia orodha kwa SMS. Jizuie",
+		),
+		"facility_" + FacilityTesting:     translations("testing", "kupimwa"),
+		"facility_" + FacilityVaccination: translations("vaccination", "chanjo"),
+		"facility_" + FacilityIsolation:   translations("isolation", "karantini"),
+		"facility_" + FacilityTreatment:   translations("treatment", "matibabu"),
+		"facility_all_day":                translations("24 hours", "saa 24"),
+		"result_question":                 translations("What was your COVID-19 test result?", "Matokeo ya kipimo chako cha COVID-19 yalikuwa yapi?"),
+		"result_positive_option":          translations("Positive", "Nimepatikana na virusi"),
+		"result_negative_option":          translations("Negative", "Sijapatikana na virusi"),
+		"result_tested_days_ago": translations(
+			"How many days ago were you tested? Enter 0 for today",
+			"Ulipimwa siku ngapi zilizopita? Andika 0 kwa leo",
+		),
+		"result_facility": translations("Where were you tested? Type the facility name", "Ulipimwa wapi? Andika jina la kituo"),
+		"result_positive": translations(
+			"Thank you for reporting. Stay in isolation until %s. We will send you an SMS every day to check on you",
+			"Asante kwa kuripoti. Kaa karantini hadi %s. Tutakutumia SMS kila siku kukujulia hali",
+		),
+		"result_isolation_over": translations(
+			"Thank you for reporting. Your isolation period ended on %s. Visit a health facility if you still feel unwell",
+			"Asante kwa kuripoti. Muda wako wa karantini uliisha %s. Tembelea kituo cha afya ikiwa bado hujisikii vizuri",
+		),
+		"result_not_saved": translations(
+			"Thank you. Your result was not saved since you chose not to share your data",
+			"Asante. Matokeo yako hayajahifadhiwa kwa sababu ulichagua kutoshiriki data yako",
+		),
+		"result_negative": translations(
+			"Thank you for reporting. Keep wearing a mask and screen yourself daily",
+			"Asante kwa kuripoti. Endelea kuvaa maski na ujichunguze kila siku",
+		),
+		"check_in_reminder": translations(
+			"KoviTrace: how are you feeling today? Dial the KoviTrace code and choose Self-Screening to check in",
+			"KoviTrace: unajisikiaje leo? Piga nambari ya KoviTrace uchague Kujichunguza ili kuripoti hali yako",
+		),
+		"check_in_intro":               translations("Daily check-in", "Kuripoti hali ya kila siku"),
+		"check_in_fever":               translations("Do you have a fever today?", "Una homa leo?"),
+		"check_in_breathing":           translations("How is your breathing?", "Unapumua vipi?"),
+		"check_in_breathing_normal":    translations("Normal", "Kawaida"),
+		"check_in_breathing_harder":    translations("Slightly difficult", "Shida kidogo"),
+		"check_in_breathing_very_hard": translations("Very difficult", "Shida kubwa"),
+		"check_in_feeling":             translations("How do you feel overall?", "Unajisikiaje kwa jumla?"),
+		"check_in_feeling_well":        translations("Well", "Vizuri"),
+		"check_in_feeling_unwell":      translations("Unwell", "Si vizuri"),
+		"check_in_feeling_very_unwell": translations("Very unwell", "Vibaya sana"),
+		"check_in_stable": translations(
+			"Thank you for checking in. Stay at home and check in again tomorrow",
+			"Asante kwa kuripoti. Kaa nyumbani na uripoti tena kesho",
+		),
+		"check_in_urgent_no_consent": translations(
+			"Your symptoms are getting worse. CALL NOW: %s",
+			"Dalili zako zinazidi. PIGA SIMU SASA: %s",
+		),
+		"check_in_urgent": translations(
+			"Your symptoms are getting worse. CALL NOW: %s\nThe follow-up team has been told and will call you",
+			"Dalili zako zinazidi. PIGA SIMU SASA: %s\nTimu ya ufuatiliaji imearifiwa na itakupigia",
+		),
+		"check_in_hotline_fallback": translations("a COVID-19 hotline", "nambari ya dharura ya COVID-19"),
+		"follow_up_alert": translations(
+			"KoviTrace: urgent check-in from %s. Score %d, fever %s, breathing %s, feeling %s. Please call",
+			"KoviTrace: hali ya dharura kutoka %s. Alama %d, homa %s, kupumua %s, hali %s. Tafadhali piga simu",
+		),
+		"exposure_no_consent": translations(
+			"Notifying contacts needs your consent to use your data. Dial again and accept to continue",
+			"Kuarifu watu wa karibu kunahitaji idhini yako ya kutumia data yako. Piga tena ukubali ili kuendelea",
+		),
+		"exposure_not_eligible": translations(
+			"Only people who reported a positive test can notify contacts. Choose Report my test result first",
+			"Ni watu walioripoti kupatikana na virusi pekee wanaoweza kuarifu. Chagua Ripoti matokeo ya kipimo kwanza",
+		),
+		"exposure_consent": translations(
+			"We will send an SMS to your close contacts that they may have been exposed to COVID-19. Your name and number will not be shared",
+			"Tutawatumia SMS watu uliokaribiana nao kuwa huenda wameambukizwa COVID-19. Jina na nambari yako haitatolewa",
+		),
+		"exposure_continue":  translations("Continue", "Endelea"),
+		"exposure_cancel":    translations("Cancel", "Ghairi"),
+		"exposure_cancelled": translations("No one was notified. Keep safe", "Hakuna aliyearifiwa. Jizuie"),
+		"exposure_phone": translations(
+			"Enter the phone number of a close contact",
+			"Andika nambari ya simu ya mtu uliyekaribiana naye",
+		),
+		"exposure_own_number": translations("Enter the number of a contact, not yours", "Andika nambari ya mtu mwingine, si yako"),
+		"exposure_more":       translations("Contact added", "Nambari imeongezwa"),
+		"exposure_add":        translations("Add another contact", "Ongeza nambari nyingine"),
+		"exposure_send":       translations("Send notifications", "Tuma arifa"),
+		"exposure_report_limit": translations(
+			"You can add up to %d contacts at a time",
+			"Unaweza kuongeza hadi nambari %d kwa wakati mmoja",
+		),
+		"exposure_daily_limit": translations(
+			"You can notify up to %d contacts a day. Try again tomorrow",
+			"Unaweza kuarifu hadi watu %d kwa siku. Jaribu tena kesho",
+		),
+		"exposure_sent": translations(
+			"Thank you. We will notify %d contacts without sharing your details",
+			"Asante. Tutawaarifu watu %d bila kutoa maelezo yako",
+		),
+		"exposure_sms": translations(
+			"KoviTrace: Someone you were in close contact with has tested positive for COVID-19. "+
+				"Stay at home, watch for symptoms and dial the KoviTrace code to screen yourself.",
+			"KoviTrace: Mtu uliyekaribiana naye amepatikana na COVID-19. "+
+				"Kaa nyumbani, angalia dalili na upige nambari ya KoviTrace ujichunguze.",
+		),
+		"risk_exposure": translations(
+			"You were reported as a close contact of a confirmed case. Stay at home and get tested",
+			"Uliripotiwa kuwa ulikaribiana na mgonjwa aliyethibitishwa. Kaa nyumbani na upimwe",
+		),
+		"worker_unregistered": translations(
+			"This number is not registered as a health worker. Contact your supervisor to register",
+			"Nambari hii haijasajiliwa kama mhudumu wa afya. Wasiliana na msimamizi wako ili usajiliwe",
+		),
+		"worker_pin": translations("Enter your health worker PIN", "Andika PIN yako ya mhudumu wa afya"),
+		"worker_wrong_pin": translations(
+			"Wrong PIN. %d attempts left",
+			"PIN si sahihi. Umebakisha majaribio %d",
+		),
+		"worker_locked": translations(
+			"Too many wrong PINs. Try again in %d minutes",
+			"PIN zisizo sahihi ni nyingi. Jaribu tena baada ya dakika %d",
+		),
+		"worker_menu":           translations("Health worker menu", "Menyu ya mhudumu wa afya"),
+		"worker_screen":         translations("Screen a person", "Mchunguze mtu"),
+		"worker_summary_option": translations("My screenings today", "Uchunguzi wangu leo"),
+		"worker_household": translations(
+			"Enter the household ID",
+			"Andika nambari ya kaya",
+		),
+		"worker_summary": translations(
+			"Screenings today: %d in %d households\nHIGH: %d\nMEDIUM: %d\nLOW: %d",
+			"Uchunguzi wa leo: %d katika kaya %d\nJUU: %d\nKATI: %d\nCHINI: %d",
+		),
+		"household_intro": translations(
+			"Screen the members of your household one at a time",
+			"Wachunguze watu wa familia yako mmoja baada ya mwingine",
+		),
+		"household_member_name":   translations("Enter the name or initials of the member", "Andika jina au herufi za kwanza za mtu huyo"),
+		"household_relationship":  translations("Who is this member to you?", "Mtu huyu ni nani kwako?"),
+		"relationship_self":       translations("Myself", "Mimi mwenyewe"),
+		"relationship_spouse":     translations("Spouse", "Mume/Mke"),
+		"relationship_child":      translations("Child", "Mtoto"),
+		"relationship_parent":     translations("Parent", "Mzazi"),
+		"relationship_sibling":    translations("Sibling", "Ndugu"),
+		"relationship_other":      translations("Other", "Mwingine"),
+		"household_member_result": translations("%s has %s risk", "%s ana hatari ya %s"),
+		"household_next":          translations("What next?", "Nini kifuatacho?"),
+		"household_add_member":    translations("Screen another member", "Chunguza mtu mwingine"),
+		"household_finish":        translations("Finish and get an SMS summary", "Maliza na upate muhtasari kwa SMS"),
+		"household_limit": translations(
+			"You can screen up to %d members in one session",
+			"Unaweza kuchunguza hadi watu %d kwa wakati mmoja",
+		),
+		"household_sms_title": translations("KoviTrace household screening results:", "Matokeo ya uchunguzi wa familia ya KoviTrace:"),
+		"household_sms_sent": translations(
+			"You screened %d members. We have sent you the results by SMS",
+			"Umechunguza watu %d. Tumekutumia matokeo kwa SMS",
+		),
+		"rate_limited": translations(
+			"You have made too many requests. Please wait a few minutes and dial again",
+			"Umetuma maombi mengi sana. Tafadhali subiri dakika chache kisha upige tena",
+		),
+		"service_busy": translations(
+			"Service busy, please try again in a moment",
+			"Huduma ina shughuli nyingi, tafadhali jaribu tena baada ya muda mfupi",
+		),
+		"risk_result": translations(
+			"You have %s risk of getting COVID-19.\nObserve the following recommendations to reduce your risk",
+			"Una hatari ya %s kupata COVID-19.\nZingatia maagizo uliyopewa ili kupunguza hatari yako",
+		),
+		"recommendations": translations(
+			"Wear mask\nAvoid congested places\nKeep social distance of 1.5 m",
+			"Vaa Maski\nEpuka maeneo yenye watu wengi\nZingatia umbali wa kijami wa 1.5 mita",
+		),
+		"risk_find_facility": translations(
+			"Dial again and choose Find a testing centre to get tested",
+			"Piga tena uchague Tafuta kituo cha kupimwa ili upimwe",
+		),
+		"risk_closing": translations(
+			"Take the questionnaire on a daily basis in order to stay updated\nSee you next time :)",
+			"Fanya jaribi hili kila siku ndiposa ujikinge zaidi.\nTutaonana wakati mwingine :)",
+		),
+		"risk_" + riskHigh:   translations(riskHigh, "JUU"),
+		"risk_" + riskMedium: translations(riskMedium, "KATI"),
+		"risk_" + riskLow:    translations(riskLow, "CHINI"),
+	}
+}
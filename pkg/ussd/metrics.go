@@ -0,0 +1,205 @@
+package ussd
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sessionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "sessions_total",
+		Help:      "Number of USSD sessions started by network code",
+	}, []string{"network_code"})
+
+	stepsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "steps_total",
+		Help:      "Number of successful responses per menu step",
+	}, []string{"step"})
+
+	answersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "answers_total",
+		Help:      "Number of times an answer option was chosen per question",
+	}, []string{"question", "answer"})
+
+	riskResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "risk_results_total",
+		Help:      "Number of screenings per risk band and questionnaire version",
+	}, []string{"band", "questionnaire"})
+
+	languagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "languages_total",
+		Help:      "Number of times a language was selected",
+	}, []string{"lang"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "errors_total",
+		Help:      "Number of failed requests by handler stage",
+	}, []string{"stage"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ussd",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of USSD requests by handler stage",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"stage"})
+
+	requestTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "request_timeouts_total",
+		Help:      "Number of requests answered with the busy screen by handler stage",
+	}, []string{"stage"})
+
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ussd",
+		Name:      "redis_duration_seconds",
+		Help:      "Latency of redis commands",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	sqlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ussd",
+		Name:      "sql_duration_seconds",
+		Help:      "Latency of SQL operations",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	contentVersionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ussd",
+		Name:      "content_version_info",
+		Help:      "Content version served to new sessions, the value is always 1",
+	}, []string{"version"})
+
+	contentReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "content_reloads_total",
+		Help:      "Number of content file changes by result",
+	}, []string{"result"})
+
+	articlesReadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "articles_read_total",
+		Help:      "Number of times an article was opened on USSD or sent by SMS",
+	}, []string{"article", "channel"})
+
+	testResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "test_results_total",
+		Help:      "Number of test results reported by users by result",
+	}, []string{"result"})
+
+	exposuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "exposures_total",
+		Help:      "Number of contacts entered for exposure notification by result",
+	}, []string{"result"})
+
+	checkInsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "check_ins_total",
+		Help:      "Number of daily check-ins completed by users in isolation or quarantine by result",
+	}, []string{"result"})
+
+	healthWorkerLoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "health_worker_logins_total",
+		Help:      "Number of health worker PIN checks by result",
+	}, []string{"result"})
+
+	householdScreeningsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "household_screenings_total",
+		Help:      "Number of household screenings finished by number of members",
+	}, []string{"members"})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "rate_limited_total",
+		Help:      "Number of requests refused by rate limit",
+	}, []string{"limit"})
+
+	excessiveScreeningsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ussd",
+		Name:      "excessive_screenings_total",
+		Help:      "Number of screenings flagged as excessive and excluded from analytics",
+	})
+)
+
+// registerMetrics registers the metrics of the handler. Metrics registered by an earlier handler are kept.
+func registerMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{
+		sessionsTotal,
+		stepsTotal,
+		answersTotal,
+		riskResultsTotal,
+		languagesTotal,
+		errorsTotal,
+		requestDuration,
+		requestTimeoutsTotal,
+		redisDuration,
+		sqlDuration,
+		contentVersionInfo,
+		contentReloadsTotal,
+		articlesReadTotal,
+		testResultsTotal,
+		exposuresTotal,
+		checkInsTotal,
+		healthWorkerLoginsTotal,
+		householdScreeningsTotal,
+		rateLimitedTotal,
+		excessiveScreeningsTotal,
+	} {
+		err := registerer.Register(collector)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+			return errors.Wrap(err, "failed to register metrics")
+		}
+	}
+	return nil
+}
+
+// observeRedis observes the latency of a redis command started at start and adds it to the timings of the request
+func observeRedis(ctx context.Context, command string, start time.Time) {
+	latency := time.Since(start)
+	redisDuration.WithLabelValues(command).Observe(latency.Seconds())
+	requestTimingsFrom(ctx).addRedis(latency)
+}
+
+const sqlStartKey = "metrics:start_time"
+
+// registerSQLMetrics observes the latency of gorm operations and adds it to the timings of the request
+func registerSQLMetrics(db *gorm.DB) {
+	before := func(scope *gorm.Scope) {
+		scope.Set(sqlStartKey, time.Now())
+	}
+	after := func(operation string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			start, ok := scope.Get(sqlStartKey)
+			if !ok {
+				return
+			}
+			latency := time.Since(start.(time.Time))
+			sqlDuration.WithLabelValues(operation, scope.TableName()).Observe(latency.Seconds())
+			if ctx, ok := scope.Get(sqlContextKey); ok {
+				requestTimingsFrom(ctx.(context.Context)).addSQL(latency)
+			}
+		}
+	}
+
+	db.Callback().Create().Before("gorm:begin_transaction").Register("metrics:before_create", before)
+	db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("metrics:after_create", after("create"))
+	db.Callback().Update().Before("gorm:begin_transaction").Register("metrics:before_update", before)
+	db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("metrics:after_update", after("update"))
+	db.Callback().Delete().Before("gorm:begin_transaction").Register("metrics:before_delete", before)
+	db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:after_delete", after("delete"))
+	db.Callback().Query().Before("gorm:query").Register("metrics:before_query", before)
+	db.Callback().Query().After("gorm:after_query").Register("metrics:after_query", after("query"))
+}
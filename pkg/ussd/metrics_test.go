@@ -0,0 +1,27 @@
+package ussd
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsRegisteredWithRegisterer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	for i := 0; i < 2; i++ {
+		newTestAPI(t, func(opt *Options) {
+			opt.Registerer = registry
+		})
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "ussd_excessive_screenings_total" {
+			return
+		}
+	}
+	t.Errorf("expected handler metrics in the registry, got %d metric families", len(families))
+}
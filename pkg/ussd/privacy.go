@@ -0,0 +1,129 @@
+package ussd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// contact holds the raw phone number of a user. It is the only table that stores phone numbers in clear
+// and is used for SMS follow-up. Analytics tables reference users by phone hash.
+type contact struct {
+	PhoneHash   string `gorm:"primary_key;type:varchar(64)"`
+	PhoneNumber string `gorm:"type:varchar(20);not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (*contact) TableName() string {
+	return "ussd_contacts"
+}
+
+// hashPhone returns a keyed hash (HMAC-SHA256) of the phone number
+func (api *ussdAPIServer) hashPhone(phone string) string {
+	return hashPhoneWithKey(api.phoneHashKey, phone)
+}
+
+func hashPhoneWithKey(key []byte, phone string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(normalizePhone(phone)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// normalizePhone strips formatting so that the same number always produces the same hash
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		return phone[1:]
+	case strings.HasPrefix(phone, "0") && len(phone) == 10:
+		return "254" + phone[1:]
+	}
+	return phone
+}
+
+func (api *ussdAPIServer) saveContact(phone string) error {
+	phoneHash := api.hashPhone(phone)
+
+	db := api.sqlDB.Where(&contact{PhoneHash: phoneHash}).
+		Assign(&contact{PhoneNumber: phone}).
+		FirstOrCreate(&contact{})
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to save contact")
+	}
+
+	// Touch the record so that retention is counted from the last visit
+	return api.sqlDB.Model(&contact{PhoneHash: phoneHash}).Update("updated_at", time.Now()).Error
+}
+
+// purgeExpiredData deletes phone numbers and unlinks screenings, test results and consents from phone hashes once they
+// are older than the retention period
+func (api *ussdAPIServer) purgeExpiredData(retention time.Duration) error {
+	cutoff := time.Now().Add(-retention)
+
+	db := api.sqlDB.Where("updated_at < ?", cutoff).Delete(&contact{})
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge contacts")
+	}
+	contacts := db.RowsAffected
+
+	db = api.sqlDB.Model(&screening{}).Where("created_at < ? AND (phone_hash != '' OR caller_hash != '')", cutoff).
+		Updates(map[string]interface{}{"phone_hash": "", "caller_hash": ""})
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge screenings")
+	}
+
+	screenings := db.RowsAffected
+
+	db = api.sqlDB.Model(&testResult{}).Where("created_at < ? AND phone_hash != ''", cutoff).Update("phone_hash", "")
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge test results")
+	}
+	results := db.RowsAffected
+
+	// The consent audit log keeps the decisions but not whose they were
+	db = api.sqlDB.Model(&consentRecord{}).Where("created_at < ? AND phone_hash != ''", cutoff).Update("phone_hash", "")
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge consents")
+	}
+	consents := db.RowsAffected
+
+	db = api.sqlDB.Where("due < ?", cutoff).Delete(&checkIn{})
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge check-ins")
+	}
+
+	db = api.sqlDB.Where("created_at < ?", cutoff).Delete(&exposure{})
+	if db.Error != nil {
+		return errors.Wrap(db.Error, "failed to purge exposures")
+	}
+
+	api.logger.Infof("retention job purged %d contacts, %d screenings, %d test results and %d consents", contacts, screenings, results, consents)
+
+	return nil
+}
+
+// runRetentionJob purges identifiable data periodically until the context is cancelled
+func (api *ussdAPIServer) runRetentionJob(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := api.purgeExpiredData(retention)
+		if err != nil {
+			api.logger.Errorf("retention job failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
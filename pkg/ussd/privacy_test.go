@@ -0,0 +1,50 @@
+package ussd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeExpiredDataUnlinksConsents(t *testing.T) {
+	api := newTestAPI(t)
+
+	old := &consentRecord{
+		PhoneHash: api.hashPhone(testPhone),
+		SessionID: "ATUid_old",
+		Version:   "v1",
+		Decision:  consentAccepted,
+		CreatedAt: time.Now().Add(-48 * time.Hour),
+	}
+	recent := &consentRecord{
+		PhoneHash: api.hashPhone(testPhone),
+		SessionID: "ATUid_recent",
+		Version:   "v1",
+		Decision:  consentDeclined,
+	}
+	for _, record := range []*consentRecord{old, recent} {
+		err := api.sqlDB.Create(record).Error
+		if err != nil {
+			t.Fatalf("failed to save consent: %v", err)
+		}
+	}
+
+	err := api.purgeExpiredData(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("failed to purge expired data: %v", err)
+	}
+
+	consents := make([]*consentRecord, 0)
+	err = api.sqlDB.Order("id").Find(&consents).Error
+	if err != nil {
+		t.Fatalf("failed to get consents: %v", err)
+	}
+	if len(consents) != 2 {
+		t.Fatalf("expected consent decisions to be kept, got %d", len(consents))
+	}
+	if consents[0].PhoneHash != "" || consents[0].Decision != consentAccepted {
+		t.Errorf("expected expired consent without phone hash, got %+v", consents[0])
+	}
+	if consents[1].PhoneHash != recent.PhoneHash {
+		t.Errorf("expected recent consent to keep its phone hash, got %+v", consents[1])
+	}
+}
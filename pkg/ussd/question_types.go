@@ -0,0 +1,347 @@
+package ussd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Question types
+const (
+	QuestionChoice  = "choice"
+	QuestionYesNo   = "yes_no"
+	QuestionInteger = "integer"
+	QuestionNumber  = "number"
+	QuestionText    = "text"
+	// QuestionCounty lists the counties. The answer scores by the number of new cases in the county in the last 14 days.
+	QuestionCounty = "county"
+	// QuestionSubCounty lists the sub-counties of the county answered in the question named by Within
+	QuestionSubCounty = "sub_county"
+	// QuestionPhone is answered with a Kenyan mobile number. The value is the number in international format.
+	QuestionPhone = "phone"
+)
+
+// Inputs that move between pages of options
+const (
+	pageBack = "0"
+	pageMore = 98
+)
+
+// questionType validates the definition of a kind of question and parses its answers
+type questionType interface {
+	validate(question *Question) error
+	// options returns the options listed below the question
+	options(question *Question, qc *questionContext) []*Option
+	parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer)
+}
+
+var questionTypes = map[string]questionType{
+	QuestionChoice:    choiceType{},
+	QuestionYesNo:     yesNoType{},
+	QuestionInteger:   numberType{integer: true},
+	QuestionNumber:    numberType{},
+	QuestionText:      textType{},
+	QuestionCounty:    countyType{},
+	QuestionSubCounty: subCountyType{},
+	QuestionPhone:     phoneType{},
+}
+
+// questionContext is what questions depend on besides their definition
+type questionContext struct {
+	// answers holds the values of the questions answered so far
+	answers   map[string][]string
+	locations []*County
+	// cases is the number of new cases in the last newCasesDays days keyed by lower case county name. It is only
+	// loaded to parse county answers.
+	cases    map[string]int
+	messages Messages
+	// page is the page of options shown
+	page int
+}
+
+// answer is a parsed answer to a question
+type answer struct {
+	values  []string
+	options []*Option
+	score   int
+}
+
+// invalidAnswer explains why an input is not a valid answer. Message is the id of a localized message
+// that takes args.
+type invalidAnswer struct {
+	message string
+	args    []interface{}
+}
+
+func (invalid *invalidAnswer) text(messages Messages, lang string) string {
+	return fmt.Sprintf(messages.text(invalid.message, lang), invalid.args...)
+}
+
+// choiceType is a question answered by choosing one or several of its options
+type choiceType struct{}
+
+func (choiceType) validate(question *Question) error {
+	if len(question.Options) == 0 {
+		return errors.Errorf("question %q has no options", question.ID)
+	}
+	return nil
+}
+
+func (choiceType) options(question *Question, qc *questionContext) []*Option {
+	return question.Options
+}
+
+func (choiceType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	return parseChoices(question.Options, question.Multiple, input)
+}
+
+func parseChoices(options []*Option, multiple bool, input string) (*answer, *invalidAnswer) {
+	choices := []string{input}
+	if multiple {
+		choices = strings.Split(input, ",")
+	}
+
+	var (
+		ans    = &answer{}
+		chosen = make(map[int]bool, len(choices))
+	)
+	for _, choice := range choices {
+		index, err := strconv.Atoi(strings.TrimSpace(choice))
+		if err != nil || index < 1 || index > len(options) {
+			return nil, &invalidAnswer{message: "invalid_option", args: []interface{}{len(options)}}
+		}
+		if chosen[index] {
+			continue
+		}
+		chosen[index] = true
+
+		option := options[index-1]
+		ans.options = append(ans.options, option)
+		ans.values = append(ans.values, option.Value)
+		ans.score += option.Score
+	}
+
+	return ans, nil
+}
+
+// yesNoType is a question answered with 1 for yes or 2 for no
+type yesNoType struct{}
+
+func (yesNoType) validate(question *Question) error {
+	if len(question.Options) > 0 {
+		return errors.Errorf("yes_no question %q can't have options", question.ID)
+	}
+	return nil
+}
+
+func (yesNoType) options(question *Question, qc *questionContext) []*Option {
+	return []*Option{
+		{Text: translations("Yes", "Ndio"), Value: "yes", Score: question.YesScore},
+		{Text: translations("No", "Hapana"), Value: "no", Score: question.NoScore},
+	}
+}
+
+func (qt yesNoType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	ans, invalid := parseChoices(qt.options(question, qc), false, input)
+	if invalid != nil {
+		return nil, &invalidAnswer{message: "invalid_yes_no"}
+	}
+	return ans, nil
+}
+
+// numberType is a question answered with a number between Min and Max
+type numberType struct {
+	integer bool
+}
+
+func (numberType) validate(question *Question) error {
+	switch {
+	case len(question.Options) > 0:
+		return errors.Errorf("%s question %q can't have options", question.kind(), question.ID)
+	case question.Max <= question.Min:
+		return errors.Errorf("%s question %q must have max greater than min", question.kind(), question.ID)
+	}
+	return nil
+}
+
+func (numberType) options(question *Question, qc *questionContext) []*Option {
+	return nil
+}
+
+func (qt numberType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	if question.Optional && input == "0" {
+		return &answer{}, nil
+	}
+
+	var (
+		number float64
+		err    error
+	)
+	if qt.integer {
+		var n int
+		n, err = strconv.Atoi(input)
+		number = float64(n)
+	} else {
+		number, err = strconv.ParseFloat(input, 64)
+	}
+	// ParseFloat accepts NaN which passes the range check
+	invalid := err != nil || math.IsNaN(number) || math.IsInf(number, 0)
+	if invalid || number < question.Min || number > question.Max {
+		message := "invalid_number"
+		if qt.integer {
+			message = "invalid_integer"
+		}
+		return nil, &invalidAnswer{message: message, args: []interface{}{formatNumber(question.Min), formatNumber(question.Max)}}
+	}
+
+	return &answer{values: []string{formatNumber(number)}, score: question.rangeScore(number)}, nil
+}
+
+// textType is a question answered with free text of MinLength to MaxLength characters
+type textType struct{}
+
+// maxTextLength is the longest text answer that can be stored
+const maxTextLength = 160
+
+func (textType) validate(question *Question) error {
+	switch {
+	case len(question.Options) > 0:
+		return errors.Errorf("text question %q can't have options", question.ID)
+	case question.MaxLength < 1 || question.MaxLength > maxTextLength:
+		return errors.Errorf("text question %q must have max length from 1 to %d", question.ID, maxTextLength)
+	case question.MinLength > question.MaxLength:
+		return errors.Errorf("text question %q must have min length up to max length", question.ID)
+	}
+	return nil
+}
+
+func (textType) options(question *Question, qc *questionContext) []*Option {
+	return nil
+}
+
+func (textType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	minLength := question.MinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+
+	length := utf8.RuneCountInString(input)
+	if length < minLength || length > question.MaxLength {
+		return nil, &invalidAnswer{message: "invalid_text", args: []interface{}{minLength, question.MaxLength}}
+	}
+
+	return &answer{values: []string{input}}, nil
+}
+
+// phoneType is a question answered with a mobile phone number
+type phoneType struct{}
+
+func (phoneType) validate(question *Question) error {
+	if len(question.Options) > 0 {
+		return errors.Errorf("phone question %q can't have options", question.ID)
+	}
+	return nil
+}
+
+func (phoneType) options(question *Question, qc *questionContext) []*Option {
+	return nil
+}
+
+func (phoneType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	phone := normalizePhone(input)
+	// Kenyan mobile numbers are 254 followed by 7 or 1 and 8 digits
+	_, err := strconv.ParseUint(phone, 10, 64)
+	if err != nil || len(phone) != 12 || !(strings.HasPrefix(phone, "2547") || strings.HasPrefix(phone, "2541")) {
+		return nil, &invalidAnswer{message: "invalid_phone"}
+	}
+	return &answer{values: []string{phone}}, nil
+}
+
+// countyType is a question answered by choosing a county
+type countyType struct{}
+
+func (countyType) validate(question *Question) error {
+	if len(question.Options) > 0 {
+		return errors.Errorf("county question %q can't have options", question.ID)
+	}
+	return nil
+}
+
+func (countyType) options(question *Question, qc *questionContext) []*Option {
+	return locationOptions(qc.locations)
+}
+
+func (qt countyType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	ans, invalid := parseChoices(qt.options(question, qc), false, input)
+	if invalid != nil {
+		return nil, invalid
+	}
+
+	// Counties without recent official figures don't add to the risk score
+	if cases, ok := qc.cases[strings.ToLower(ans.values[0])]; ok {
+		ans.score = question.rangeScore(float64(cases))
+	}
+
+	return ans, nil
+}
+
+// subCountyType is a question answered by choosing a sub-county of the county answered earlier
+type subCountyType struct{}
+
+func (subCountyType) validate(question *Question) error {
+	switch {
+	case len(question.Options) > 0:
+		return errors.Errorf("sub_county question %q can't have options", question.ID)
+	case question.Within == "":
+		return errors.Errorf("sub_county question %q must be within a county question", question.ID)
+	}
+	return nil
+}
+
+func (subCountyType) options(question *Question, qc *questionContext) []*Option {
+	values := qc.answers[question.Within]
+	if len(values) == 0 {
+		return nil
+	}
+	county := findCounty(qc.locations, values[0])
+	if county == nil {
+		return nil
+	}
+
+	options := make([]*Option, 0, len(county.SubCounties))
+	for _, subCounty := range county.SubCounties {
+		options = append(options, &Option{Text: translations(subCounty, subCounty), Value: subCounty})
+	}
+	return options
+}
+
+func (qt subCountyType) parse(question *Question, qc *questionContext, input string) (*answer, *invalidAnswer) {
+	return parseChoices(qt.options(question, qc), false, input)
+}
+
+func locationOptions(counties []*County) []*Option {
+	options := make([]*Option, 0, len(counties))
+	for _, county := range counties {
+		options = append(options, &Option{Text: translations(county.Name, county.Name), Value: county.Name})
+	}
+	return options
+}
+
+// rangeScore returns the sum of the scores of the ranges the number falls in
+func (question *Question) rangeScore(number float64) int {
+	score := 0
+	for _, r := range question.Scores {
+		if number >= r.Min && number < r.Max {
+			score += r.Score
+		}
+	}
+	return score
+}
+
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
@@ -0,0 +1,86 @@
+package ussd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuestionTypes(t *testing.T) {
+	var (
+		choice = &Question{ID: "choice", Multiple: true, Options: []*Option{
+			{Value: "a", Score: 1}, {Value: "b", Score: 2}, {Value: "c"},
+		}}
+		yesNo   = &Question{ID: "yes_no", Type: QuestionYesNo, YesScore: 3}
+		integer = &Question{ID: "integer", Type: QuestionInteger, Min: 0, Max: 120, Scores: []*RangeScore{{Min: 60, Max: 121, Score: 3}}}
+		number  = &Question{ID: "number", Type: QuestionNumber, Min: 34, Max: 43, Optional: true}
+		text    = &Question{ID: "text", Type: QuestionText, MinLength: 3, MaxLength: 5}
+		phone   = &Question{ID: "phone", Type: QuestionPhone}
+	)
+
+	cases := []struct {
+		question *Question
+		input    string
+		values   []string
+		score    int
+		invalid  string
+	}{
+		{question: choice, input: "1,2", values: []string{"a", "b"}, score: 3},
+		{question: choice, input: " 2, 2 ", values: []string{"b"}, score: 2},
+		{question: choice, input: "1,4", invalid: "invalid_option"},
+		{question: choice, input: "", invalid: "invalid_option"},
+		{question: yesNo, input: "1", values: []string{"yes"}, score: 3},
+		{question: yesNo, input: "2", values: []string{"no"}},
+		{question: yesNo, input: "3", invalid: "invalid_yes_no"},
+		{question: integer, input: "65", values: []string{"65"}, score: 3},
+		{question: integer, input: "30", values: []string{"30"}},
+		{question: integer, input: "30.5", invalid: "invalid_integer"},
+		{question: integer, input: "121", invalid: "invalid_integer"},
+		{question: number, input: "38.5", values: []string{"38.5"}},
+		{question: number, input: "0"},
+		{question: number, input: "33", invalid: "invalid_number"},
+		{question: number, input: "NaN", invalid: "invalid_number"},
+		{question: number, input: "Inf", invalid: "invalid_number"},
+		{question: text, input: "Kisii", values: []string{"Kisii"}},
+		{question: text, input: "Ke", invalid: "invalid_text"},
+		{question: text, input: "Nairobi", invalid: "invalid_text"},
+		{question: phone, input: "0712 345 678", values: []string{"254712345678"}},
+		{question: phone, input: "+254110345678", values: []string{"254110345678"}},
+		{question: phone, input: "0202345678", invalid: "invalid_phone"},
+		{question: phone, input: "07123456", invalid: "invalid_phone"},
+	}
+
+	for _, c := range cases {
+		ans, invalid := c.question.parse(&questionContext{}, c.input)
+		if c.invalid != "" {
+			if invalid == nil || invalid.message != c.invalid {
+				t.Errorf("%s %q: expected %s, got %+v", c.question.ID, c.input, c.invalid, invalid)
+			}
+			continue
+		}
+		if invalid != nil {
+			t.Errorf("%s %q: unexpected %s", c.question.ID, c.input, invalid.message)
+			continue
+		}
+		if !reflect.DeepEqual(ans.values, c.values) || ans.score != c.score {
+			t.Errorf("%s %q: expected %v scoring %d, got %v scoring %d", c.question.ID, c.input, c.values, c.score, ans.values, ans.score)
+		}
+	}
+}
+
+func TestQuestionTypesValidate(t *testing.T) {
+	invalid := []*Question{
+		{ID: "choice"},
+		{ID: "yes_no", Type: QuestionYesNo, Options: []*Option{{Value: "maybe"}}},
+		{ID: "integer", Type: QuestionInteger, Min: 10, Max: 10},
+		{ID: "text", Type: QuestionText, MaxLength: 500},
+		{ID: "text", Type: QuestionText, MinLength: 10, MaxLength: 5},
+		{ID: "unknown", Type: "date"},
+	}
+
+	for _, question := range invalid {
+		err := question.validate(map[string]bool{})
+		if err == nil {
+			t.Errorf("expected %s question %+v to be invalid", question.kind(), question)
+		}
+	}
+}
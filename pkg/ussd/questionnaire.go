@@ -0,0 +1,565 @@
+package ussd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Questionnaire is the list of questions asked during self-screening. Texts are keyed by language code.
+type Questionnaire struct {
+	// Version identifies the questions and scores. It is saved with every screening so that results of
+	// different versions are not compared. Change it whenever questions or scores change.
+	Version   string            `yaml:"version"`
+	Intro     map[string]string `yaml:"intro,omitempty"`
+	Questions []*Question       `yaml:"questions"`
+}
+
+// Question is a single screening question
+type Question struct {
+	// ID identifies the question in sessions, stored answers and metrics
+	ID string `yaml:"id"`
+	// Type is one of choice, yes_no, integer, number or text. Defaults to choice.
+	Type string            `yaml:"type,omitempty"`
+	Text map[string]string `yaml:"text"`
+	// Hint is shown below the options
+	Hint map[string]string `yaml:"hint,omitempty"`
+	// Multiple allows selecting several options separated by commas
+	Multiple bool      `yaml:"multiple,omitempty"`
+	Options  []*Option `yaml:"options,omitempty"`
+	// Min and Max bound the answer of integer and number questions
+	Min float64 `yaml:"min,omitempty"`
+	Max float64 `yaml:"max,omitempty"`
+	// Optional integer and number questions are skipped by entering 0
+	Optional bool `yaml:"optional,omitempty"`
+	// Scores add to the risk score by the range the integer or number answer falls in
+	Scores []*RangeScore `yaml:"scores,omitempty"`
+	// MinLength and MaxLength bound the answer of text questions
+	MinLength int `yaml:"minLength,omitempty"`
+	MaxLength int `yaml:"maxLength,omitempty"`
+	// YesScore and NoScore are the scores of yes_no answers
+	YesScore int `yaml:"yesScore,omitempty"`
+	NoScore  int `yaml:"noScore,omitempty"`
+	// FollowUps are asked right after the question when the answer adds to the risk score
+	FollowUps []*Question `yaml:"followUps,omitempty"`
+	// ShowIf lists conditions on earlier answers that must all match for the question to be asked
+	ShowIf []*Condition `yaml:"showIf,omitempty"`
+	// PageSize splits long lists of options into pages. Zero shows all options.
+	PageSize int `yaml:"pageSize,omitempty"`
+	// Within is the id of the county question that a sub_county question narrows down
+	Within string `yaml:"within,omitempty"`
+}
+
+// Condition matches when the answer to the question has any of the values
+type Condition struct {
+	Question string   `yaml:"question"`
+	Values   []string `yaml:"values"`
+}
+
+// Option is an answer to a question
+type Option struct {
+	Text map[string]string `yaml:"text"`
+	// Value is what is stored as the answer
+	Value string `yaml:"value"`
+	// Score is added to the user risk score when the option is chosen
+	Score int `yaml:"score"`
+	// SkipTo is the id of a later question to continue with when the option is chosen. "end" ends the screening.
+	SkipTo string `yaml:"skipTo,omitempty"`
+}
+
+// skipToEnd ends the screening when used as an option's SkipTo
+const skipToEnd = "end"
+
+// RangeScore is the score of number answers from Min up to but not including Max
+type RangeScore struct {
+	Min   float64 `yaml:"min"`
+	Max   float64 `yaml:"max"`
+	Score int     `yaml:"score"`
+}
+
+// Validate checks that the questionnaire can be served
+func (q *Questionnaire) Validate() error {
+	switch {
+	case q.Version == "":
+		return errors.New("questionnaire has no version")
+	case len(q.Questions) == 0:
+		return errors.New("questionnaire has no questions")
+	}
+
+	ids := make(map[string]bool, len(q.Questions))
+	for index, question := range q.Questions {
+		if question.ID == "" {
+			return errors.Errorf("question %d has no id", index+1)
+		}
+		err := question.validate(ids)
+		if err != nil {
+			return err
+		}
+		for _, followUp := range question.FollowUps {
+			if followUp.ID == "" {
+				return errors.Errorf("follow-up of %q has no id", question.ID)
+			}
+			if len(followUp.FollowUps) > 0 {
+				return errors.Errorf("follow-up %q can't have follow-ups", followUp.ID)
+			}
+			err = followUp.validate(ids)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Conditions may only refer to earlier questions and skips to later ones so that the flow can't loop
+	questions := q.questions()
+	for index, question := range questions {
+		for _, condition := range question.ShowIf {
+			_, at := q.question(condition.Question)
+			if at < 0 || at >= index {
+				return errors.Errorf("question %q shows on %q which is not an earlier question", question.ID, condition.Question)
+			}
+			if len(condition.Values) == 0 {
+				return errors.Errorf("question %q has a condition on %q without values", question.ID, condition.Question)
+			}
+		}
+		if question.kind() == QuestionSubCounty {
+			within, at := q.question(question.Within)
+			if at < 0 || at >= index || within.kind() != QuestionCounty {
+				return errors.Errorf("sub_county question %q must be within an earlier county question", question.ID)
+			}
+		}
+		if len(question.Options) >= pageMore {
+			return errors.Errorf("question %q has more than %d options", question.ID, pageMore-1)
+		}
+		for _, option := range question.Options {
+			if option.SkipTo == "" || option.SkipTo == skipToEnd {
+				continue
+			}
+			_, at := q.question(option.SkipTo)
+			if at <= index {
+				return errors.Errorf("option %q of %q skips to %q which is not a later question", option.Value, question.ID, option.SkipTo)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (question *Question) validate(ids map[string]bool) error {
+	if ids[question.ID] {
+		return errors.Errorf("question id %q is used more than once", question.ID)
+	}
+	ids[question.ID] = true
+
+	qt, ok := questionTypes[question.kind()]
+	if !ok {
+		return errors.Errorf("question %q has unknown type %q", question.ID, question.Type)
+	}
+
+	return qt.validate(question)
+}
+
+// kind returns the question type
+func (question *Question) kind() string {
+	if question.Type == "" {
+		return QuestionChoice
+	}
+	return question.Type
+}
+
+// questions returns the questions in the order they are asked, with follow-ups after their question
+func (q *Questionnaire) questions() []*Question {
+	questions := make([]*Question, 0, len(q.Questions))
+	for _, question := range q.Questions {
+		questions = append(questions, question)
+		questions = append(questions, question.FollowUps...)
+	}
+	return questions
+}
+
+func (q *Questionnaire) question(id string) (*Question, int) {
+	for index, question := range q.questions() {
+		if question.ID == id {
+			return question, index
+		}
+	}
+	return nil, -1
+}
+
+// next returns the question to ask after the answer to the current question or nil if the screening is done.
+// Answers holds the values of every question answered so far.
+func (q *Questionnaire) next(current *Question, ans *answer, answers map[string][]string) *Question {
+	_, from := q.question(current.ID)
+	if from < 0 {
+		return nil
+	}
+	from++
+
+	for _, option := range ans.options {
+		if option.SkipTo == skipToEnd {
+			return nil
+		}
+		if option.SkipTo != "" {
+			_, from = q.question(option.SkipTo)
+			break
+		}
+	}
+
+	// Follow-ups are not asked for answers that don't add to the risk score or for skipped questions
+	noFollowUps := map[*Question]bool{current: ans.score == 0}
+
+	for _, question := range q.questions()[from:] {
+		if parent := q.parent(question); parent != nil && noFollowUps[parent] {
+			continue
+		}
+		if !question.shown(answers) {
+			noFollowUps[question] = true
+			continue
+		}
+		return question
+	}
+
+	return nil
+}
+
+// parent returns the question the follow-up belongs to or nil for top level questions
+func (q *Questionnaire) parent(followUp *Question) *Question {
+	for _, question := range q.Questions {
+		if question.hasFollowUp(followUp) {
+			return question
+		}
+	}
+	return nil
+}
+
+// shown reports whether the conditions of the question match the answers
+func (question *Question) shown(answers map[string][]string) bool {
+	for _, condition := range question.ShowIf {
+		if !condition.matches(answers[condition.Question]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (condition *Condition) matches(values []string) bool {
+	for _, value := range values {
+		for _, v := range condition.Values {
+			if value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (question *Question) hasFollowUp(followUp *Question) bool {
+	for _, f := range question.FollowUps {
+		if f == followUp {
+			return true
+		}
+	}
+	return false
+}
+
+// render returns the question screen. The notice is shown above the question, e.g. to explain an invalid answer.
+func (q *Questionnaire) render(question *Question, qc *questionContext, lang, notice string, first bool) string {
+	response := "CON "
+
+	if first && q.Intro[lang] != "" {
+		response += q.Intro[lang] + "\n"
+	}
+
+	var (
+		options = questionTypes[question.kind()].options(question, qc)
+		start   = 0
+		end     = len(options)
+	)
+	if question.PageSize > 0 {
+		start = qc.page * question.PageSize
+		if start >= len(options) {
+			start = 0
+		}
+		if start+question.PageSize < end {
+			end = start + question.PageSize
+		}
+	}
+
+	lines := make([]string, 0, end-start+5)
+	if notice != "" {
+		lines = append(lines, notice)
+	}
+	lines = append(lines, question.Text[lang])
+	// Options keep their number on every page so that any option can be chosen from any page
+	for index := start; index < end; index++ {
+		lines = append(lines, fmt.Sprintf("%d. %s", index+1, options[index].Text[lang]))
+	}
+	if end < len(options) {
+		lines = append(lines, fmt.Sprintf("%d. %s", pageMore, qc.messages.text("page_more", lang)))
+	}
+	if start > 0 {
+		lines = append(lines, fmt.Sprintf("%s. %s", pageBack, qc.messages.text("page_back", lang)))
+	}
+	if question.Hint[lang] != "" {
+		lines = append(lines, question.Hint[lang])
+	}
+
+	return response + strings.Join(lines, "\n")
+}
+
+// parse reads the answer from the input
+func (question *Question) parse(qc *questionContext, input string) (*answer, *invalidAnswer) {
+	return questionTypes[question.kind()].parse(question, qc, strings.TrimSpace(input))
+}
+
+// turnPage returns the page to show if the input moves between pages of options
+func (question *Question) turnPage(qc *questionContext, input string) (int, bool) {
+	if question.PageSize == 0 {
+		return 0, false
+	}
+
+	options := questionTypes[question.kind()].options(question, qc)
+	switch strings.TrimSpace(input) {
+	case strconv.Itoa(pageMore):
+		if (qc.page+1)*question.PageSize < len(options) {
+			return qc.page + 1, true
+		}
+		return qc.page, true
+	case pageBack:
+		if qc.page > 0 {
+			return qc.page - 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// inputFlow replays the inputs entered after the service against the questions of the service. Services rebuild
+// their screen from the inputs on every request so they don't need session state.
+type inputFlow struct {
+	questionnaire *Questionnaire
+	qc            *questionContext
+	lang          string
+	// question is answered by the next input. Inputs are passed to the service while it is nil.
+	question *Question
+	// notice tells why the last input was rejected
+	notice string
+	// paged is set when the last input turned the page of the question
+	paged bool
+}
+
+func newInputFlow(ussd *ussdPayload, lang string, first *Question) *inputFlow {
+	return &inputFlow{
+		questionnaire: ussd.content.Questionnaire,
+		qc: &questionContext{
+			answers:   make(map[string][]string),
+			locations: ussd.content.Locations,
+			messages:  ussd.content.Messages,
+		},
+		lang:     lang,
+		question: first,
+	}
+}
+
+// serviceInputs returns the inputs after the language and the service
+func serviceInputs(ussd *ussdPayload) []string {
+	return strings.Split(ussd.Text, "*")[2:]
+}
+
+// replay turns the pages of the question or answers it with every input. Valid answers are kept in the question
+// context and passed to answered, which moves the flow to the next question. Inputs that come while there is no
+// question are passed to other, or ignored if it is nil. Either can end the flow with a response.
+func (flow *inputFlow) replay(inputs []string, answered func(ans *answer, last bool) (string, error), other func(input string, last bool) (string, error)) (string, error) {
+	for index, input := range inputs {
+		last := index == len(inputs)-1
+		question := flow.question
+
+		if question == nil {
+			if other == nil {
+				break
+			}
+			response, err := other(input, last)
+			if response != "" || err != nil {
+				return response, err
+			}
+			continue
+		}
+
+		if page, ok := question.turnPage(flow.qc, input); ok {
+			flow.qc.page = page
+			flow.paged = last
+			continue
+		}
+
+		ans, invalid := question.parse(flow.qc, input)
+		if invalid != nil {
+			if last {
+				flow.notice = invalid.text(flow.qc.messages, flow.lang)
+			}
+			continue
+		}
+		flow.qc.answers[question.ID] = ans.values
+
+		response, err := answered(ans, last)
+		if response != "" || err != nil {
+			return response, err
+		}
+	}
+
+	return "", nil
+}
+
+// render shows the question of the flow with the notice of the last input
+func (flow *inputFlow) render() string {
+	return flow.questionnaire.render(flow.question, flow.qc, flow.lang, flow.notice, false)
+}
+
+// nextQuestion returns the question after question or nil after the last one
+func nextQuestion(questions []*Question, question *Question) *Question {
+	for index := range questions[:len(questions)-1] {
+		if questions[index] == question {
+			return questions[index+1]
+		}
+	}
+	return nil
+}
+
+func translations(english, swahili string) map[string]string {
+	return map[string]string{eng: english, swa: swahili}
+}
+
+// DefaultQuestionnaire returns the COVID-19 self-screening questionnaire
+func DefaultQuestionnaire() *Questionnaire {
+	return &Questionnaire{
+		Version: "v5",
+		Intro: translations(
+			"Welcome to KoviTrace Self screenig. Provide honest response.",
+			"Karibu kwenye KoviTrace uchunguzi wa kibinafsi. Toa mwitikio wa kweli",
+		),
+		Questions: []*Question{
+			{
+				ID:   "age",
+				Type: QuestionInteger,
+				Text: translations("How old are you?", "Una miaka mingapi?"),
+				Hint: translations("Enter your age in years", "Andika umri wako kwa miaka"),
+				Min:  0,
+				Max:  120,
+				Scores: []*RangeScore{
+					{Min: 0, Max: 25, Score: 1},
+					{Min: 25, Max: 60, Score: 2},
+					{Min: 60, Max: 121, Score: 3},
+				},
+			},
+			{
+				ID:       "county",
+				Type:     QuestionCounty,
+				Text:     translations("Which county are you in?", "Uko kaunti gani?"),
+				PageSize: 7,
+				// Scored by the official number of new cases in the county in the last 14 days
+				Scores: []*RangeScore{
+					{Min: 1, Max: 100, Score: 1},
+					{Min: 100, Max: 1e9, Score: 2},
+				},
+			},
+			{
+				ID:       "sub_county",
+				Type:     QuestionSubCounty,
+				Within:   "county",
+				Text:     translations("Which sub-county are you in?", "Uko kaunti ndogo gani?"),
+				PageSize: 7,
+			},
+			{
+				ID: "contact",
+				Text: translations(
+					"Have you been in contact with a suspected or confiimed COVID-19 case?",
+					"Je! Ushawai karibiana na mgonjwa anayeshukiwa au aliyethibitika kuwa na COVID-19?",
+				),
+				Options: []*Option{
+					{Text: translations("Yes", "Ndio"), Value: "yes", Score: 3},
+					{Text: translations("No", "Hapana"), Value: "no", Score: 1},
+					{Text: translations("Not Sure", "Sina hakika"), Value: "uknown", Score: 1},
+				},
+			},
+			{
+				ID:       "contact_how",
+				ShowIf:   []*Condition{{Question: "contact", Values: []string{"yes"}}},
+				Text:     translations("Have did the contact happened?", "Je! Mapatano yalikuwaje?"),
+				Hint:     translations("Use commas for multiple answers", "Tumia comma kutenganisha majibu"),
+				Multiple: true,
+				Options: []*Option{
+					{Text: translations("Working together", "Kufanya kazi pamoja"), Value: "working together", Score: 1},
+					{Text: translations("Face to face contact", "Uso wa uso"), Value: "face to face contact within 1 meter", Score: 2},
+					{Text: translations("Travelling together", "Kusafiri pamoja"), Value: "travelling together", Score: 1},
+					{Text: translations("Living in same environment", "Kuishi katika mazingira sawa"), Value: "living in the same environment", Score: 2},
+					{Text: translations("Healthcare associated exposure", "Kupeana matibabu"), Value: "health care associated exposure", Score: 2},
+					{Text: translations("None", "Hakuna"), Value: "none", Score: 0},
+				},
+			},
+			{
+				ID:       "symptoms",
+				Text:     translations("Do you have any of the following symptoms?", "Je! Una dalili zifuatazo?"),
+				Multiple: true,
+				Options: []*Option{
+					{Text: translations("Difficulty in breathing", "Ugumu wa kupumua"), Value: "difficulty in breathing", Score: 1},
+					{Text: translations("Cough", "Kikohozi"), Value: "cough", Score: 1},
+					{Text: translations("Tiredness/Fatigue", "Uchovu"), Value: "fatigue", Score: 1},
+					{Text: translations("Fever", "Homa"), Value: "fever", Score: 1},
+					{Text: translations("Loss of taste or smell", "Kupoteza ladha au harufu"), Value: "loss of taste or smell", Score: 2},
+					{Text: translations("Sore throat", "Kuwashwa koo"), Value: "sore throat", Score: 1},
+					{Text: translations("None of the above", "Hakuna"), Value: "none of the above", Score: 0},
+				},
+				FollowUps: []*Question{
+					{
+						ID:   "symptoms_onset",
+						Type: QuestionNumber,
+						Text: translations("How many days ago did the symptoms start?", "Dalili zilianza siku ngapi zilizopita?"),
+						Hint: translations("Enter number of days", "Andika idadi ya siku"),
+						Min:  0,
+						Max:  60,
+						Scores: []*RangeScore{
+							{Min: 0, Max: 15, Score: 2},
+						},
+					},
+					{
+						ID:   "symptoms_severity",
+						Text: translations("How bad are the symptoms?", "Dalili ni mbaya kiasi gani?"),
+						Options: []*Option{
+							{Text: translations("Mild", "Kidogo"), Value: "mild", Score: 0},
+							{Text: translations("Moderate", "Wastani"), Value: "moderate", Score: 1},
+							{Text: translations("Severe", "Mbaya sana"), Value: "severe", Score: 3},
+						},
+					},
+					{
+						ID:       "temperature",
+						Type:     QuestionNumber,
+						Text:     translations("What is your temperature in degrees Celsius?", "Joto la mwili wako ni nyuzi ngapi?"),
+						Hint:     translations("Enter 0 if not measured", "Andika 0 kama hujapima"),
+						Min:      34,
+						Max:      43,
+						Optional: true,
+						Scores: []*RangeScore{
+							{Min: 37.5, Max: 38, Score: 1},
+							{Min: 38, Max: 43.1, Score: 3},
+						},
+					},
+				},
+			},
+			{
+				ID:       "illness",
+				Text:     translations("Do you have any of the following?", "Je! Unaugua yoyote yafuatayo?"),
+				Multiple: true,
+				Options: []*Option{
+					{Text: translations("Diabetes", "Ugonjwa wa sukari"), Value: "diabetes", Score: 1},
+					{Text: translations("Asthmatic", "Pumu"), Value: "asthmatic", Score: 2},
+					{Text: translations("Cancer", "Saratani"), Value: "cancer", Score: 1},
+					{Text: translations("Hyper Tension", "Shinikizo la damu"), Value: "hyper tension", Score: 2},
+					{Text: translations("Tuberclosis", "Kifua kikuu"), Value: "tuberclosis", Score: 2},
+					{Text: translations("Respiratory illness", "Ugonjwa wa kupumua"), Value: "respiratory illness", Score: 2},
+					{Text: translations("None of the above", "Hakuna yaliyo hapo juu"), Value: "none of the above", Score: 0},
+				},
+			},
+		},
+	}
+}
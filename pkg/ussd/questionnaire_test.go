@@ -0,0 +1,83 @@
+package ussd
+
+import "testing"
+
+func TestQuestionnaireBranching(t *testing.T) {
+	q := &Questionnaire{
+		Version: "test",
+		Questions: []*Question{
+			{ID: "contact", Options: []*Option{
+				{Value: "yes", Score: 3},
+				{Value: "no"},
+				{Value: "refused", SkipTo: "illness"},
+				{Value: "quit", SkipTo: skipToEnd},
+			}},
+			{ID: "contact_how", ShowIf: []*Condition{{Question: "contact", Values: []string{"yes"}}}, Options: []*Option{
+				{Value: "face to face", Score: 2},
+			}, FollowUps: []*Question{
+				{ID: "contact_days", Type: QuestionInteger, Min: 0, Max: 30},
+			}},
+			{ID: "symptoms", Options: []*Option{{Value: "cough", Score: 1}}},
+			{ID: "illness", Options: []*Option{{Value: "none"}}},
+		},
+	}
+	if err := q.Validate(); err != nil {
+		t.Fatalf("invalid questionnaire: %v", err)
+	}
+
+	contact, _ := q.question("contact")
+
+	cases := []struct {
+		choice string
+		next   string
+	}{
+		{"1", "contact_how"},
+		{"2", "symptoms"},
+		{"3", "illness"},
+		{"4", ""},
+	}
+	for _, c := range cases {
+		ans, invalid := contact.parse(&questionContext{}, c.choice)
+		if invalid != nil {
+			t.Fatalf("unexpected invalid answer %q", c.choice)
+		}
+		answers := map[string][]string{"contact": ans.values}
+
+		var got string
+		if next := q.next(contact, ans, answers); next != nil {
+			got = next.ID
+		}
+		if got != c.next {
+			t.Errorf("contact %s: expected next question %q, got %q", ans.values, c.next, got)
+		}
+	}
+
+	// Follow-ups are asked only after answers that add to the score
+	contactHow, _ := q.question("contact_how")
+	ans, _ := contactHow.parse(&questionContext{}, "1")
+	if next := q.next(contactHow, ans, map[string][]string{"contact": {"yes"}}); next == nil || next.ID != "contact_days" {
+		t.Errorf("expected follow-up contact_days, got %v", next)
+	}
+}
+
+func TestQuestionnaireValidateBranching(t *testing.T) {
+	invalid := map[string]*Questionnaire{
+		"condition on later question": {Version: "test", Questions: []*Question{
+			{ID: "a", ShowIf: []*Condition{{Question: "b", Values: []string{"yes"}}}, Options: []*Option{{Value: "x"}}},
+			{ID: "b", Type: QuestionYesNo},
+		}},
+		"skip backwards": {Version: "test", Questions: []*Question{
+			{ID: "a", Options: []*Option{{Value: "x"}}},
+			{ID: "b", Options: []*Option{{Value: "x", SkipTo: "a"}}},
+		}},
+		"skip to unknown question": {Version: "test", Questions: []*Question{
+			{ID: "a", Options: []*Option{{Value: "x", SkipTo: "c"}}},
+		}},
+	}
+
+	for name, q := range invalid {
+		if err := q.Validate(); err == nil {
+			t.Errorf("%s: expected questionnaire to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package ussd
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// RateLimit is a token bucket holding Burst requests that refills completely every Per. Zero Burst disables it.
+type RateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRateLimit parses limits like 30/10m, i.e 30 requests every 10 minutes. An empty string disables the limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, errors.Errorf("invalid rate limit %q, use requests/duration e.g 30/10m", s)
+	}
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst < 0 {
+		return RateLimit{}, errors.Errorf("invalid rate limit requests %q", parts[0])
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, errors.Errorf("invalid rate limit duration %q", parts[1])
+	}
+
+	return RateLimit{Burst: burst, Per: per}, nil
+}
+
+// DefaultPhoneRateLimit is the number of sessions a phone can start before it is refused
+const DefaultPhoneRateLimit = "20/10m"
+
+// RateLimitOptions are the limits applied to USSD requests
+type RateLimitOptions struct {
+	// PerPhone limits the sessions started by one phone number
+	PerPhone RateLimit
+	// PerNetwork limits the requests of one mobile network
+	PerNetwork RateLimit
+	// MaxScreeningsPerDay is the number of screening sessions of a phone in 24 hours after which screenings are
+	// flagged as excessive and excluded from analytics. A household counts once and screenings by health workers
+	// are not counted. Zero disables flagging.
+	MaxScreeningsPerDay int
+}
+
+// RateLimiter takes tokens from token buckets
+type RateLimiter interface {
+	// Allow takes a token from the bucket of the key and reports whether there was one
+	Allow(key string, limit RateLimit) (bool, error)
+	// WithContext returns a limiter whose calls are cancelled with the context
+	WithContext(ctx context.Context) RateLimiter
+}
+
+// tokenBucketScript refills the bucket for the time since the last request then takes a token. Tokens and the
+// time of the last request are kept in a hash that expires once the bucket would be full.
+var tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * burst / per)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], per)
+return allowed
+`)
+
+type redisRateLimiter struct {
+	client *redis.Client
+	// ctx is the context of the request the calls are timed for
+	ctx context.Context
+}
+
+// NewRedisRateLimiter creates a rate limiter that keeps token buckets in redis so that limits are shared by
+// all instances
+func NewRedisRateLimiter(client *redis.Client) RateLimiter {
+	return &redisRateLimiter{client: client, ctx: context.Background()}
+}
+
+func (limiter *redisRateLimiter) Allow(key string, limit RateLimit) (bool, error) {
+	if limit.Burst == 0 {
+		return true, nil
+	}
+
+	defer observeRedis(limiter.ctx, "evalsha", time.Now())
+	allowed, err := tokenBucketScript.Run(limiter.client, []string{"ratelimit:" + key},
+		limit.Burst, limit.Per.Milliseconds(), time.Now().UnixNano()/int64(time.Millisecond)).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to take rate limit token")
+	}
+
+	return allowed == 1, nil
+}
+
+func (limiter *redisRateLimiter) WithContext(ctx context.Context) RateLimiter {
+	return &redisRateLimiter{client: limiter.client.WithContext(ctx), ctx: ctx}
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// per is the refill period of the limit, the bucket is full again once it was idle that long
+	per time.Duration
+}
+
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimiter creates a rate limiter that keeps token buckets in process memory.
+// It is meant for single instance deployments and tests.
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (limiter *memoryRateLimiter) Allow(key string, limit RateLimit) (bool, error) {
+	if limit.Burst == 0 {
+		return true, nil
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	// Remove buckets that are full again once in a while so that idle phones don't pile up
+	if now.Sub(limiter.lastSweep) > time.Minute {
+		for k, bucket := range limiter.buckets {
+			if now.Sub(bucket.updated) > bucket.per {
+				delete(limiter.buckets, k)
+			}
+		}
+		limiter.lastSweep = now
+	}
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now, per: limit.Per}
+		limiter.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updated)
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+float64(limit.Burst)*elapsed.Seconds()/limit.Per.Seconds())
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, nil
+	}
+	bucket.tokens--
+	return true, nil
+}
+
+// WithContext returns the limiter itself, memory calls don't block
+func (limiter *memoryRateLimiter) WithContext(ctx context.Context) RateLimiter {
+	return limiter
+}
+
+// allowRequest checks the rate limits of the phone and network of the request. Requests are allowed when the
+// limiter fails so that an outage of the limiter doesn't stop screenings.
+func (api *ussdAPIServer) allowRequest(ussd *ussdPayload) bool {
+	type check struct {
+		name  string
+		key   string
+		limit RateLimit
+	}
+	checks := []check{
+		{name: "network", key: "network:" + ussd.NetworkCode, limit: api.rateLimits.PerNetwork},
+	}
+	// Only session starts count against the phone so that long sessions like household screenings are not cut
+	// short. Inputs within a session are paced by the gateway.
+	if ussd.Text == "" {
+		checks = append(checks, check{name: "phone", key: "phone:" + api.hashPhone(ussd.PhoneNumber), limit: api.rateLimits.PerPhone})
+	}
+
+	for _, check := range checks {
+		allowed, err := api.rateLimiter.Allow(check.key, check.limit)
+		if err != nil {
+			api.logger.Errorf("failed to check %s rate limit: %v", check.name, err)
+			continue
+		}
+		if !allowed {
+			rateLimitedTotal.WithLabelValues(check.name).Inc()
+			return false
+		}
+	}
+
+	return true
+}
+
+// excessiveScreening reports whether the phone has done more than MaxScreeningsPerDay screening sessions in 24
+// hours. All the members of a household screened in one session count once. Health workers are authenticated with
+// their PIN so their screenings are not counted.
+func (api *ussdAPIServer) excessiveScreening(callerHash, sessionID string, now time.Time) (bool, error) {
+	if api.rateLimits.MaxScreeningsPerDay == 0 || callerHash == "" {
+		return false, nil
+	}
+
+	var sessions []string
+	err := api.sqlDB.Model(&screening{}).
+		Where("caller_hash = ? AND health_worker_id IS NULL AND session_id <> ? AND created_at > ?", callerHash, sessionID, now.Add(-24*time.Hour)).
+		Pluck("DISTINCT session_id", &sessions).Error
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count screening sessions")
+	}
+
+	return len(sessions) >= api.rateLimits.MaxScreeningsPerDay, nil
+}
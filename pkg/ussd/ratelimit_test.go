@@ -0,0 +1,220 @@
+package ussd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  RateLimit
+		err   bool
+	}{
+		{value: "", want: RateLimit{}},
+		{value: "30/10m", want: RateLimit{Burst: 30, Per: 10 * time.Minute}},
+		{value: "5/1s", want: RateLimit{Burst: 5, Per: time.Second}},
+		{value: "30", err: true},
+		{value: "x/1m", err: true},
+		{value: "30/0s", err: true},
+	} {
+		got, err := ParseRateLimit(c.value)
+		switch {
+		case c.err && err == nil:
+			t.Errorf("%q: expected error", c.value)
+		case !c.err && err != nil:
+			t.Errorf("%q: unexpected error %v", c.value, err)
+		case got != c.want:
+			t.Errorf("%q: expected %+v, got %+v", c.value, c.want, got)
+		}
+	}
+}
+
+func TestRateLimiters(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start redis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	for name, limiter := range map[string]RateLimiter{
+		"memory": NewMemoryRateLimiter(),
+		"redis":  NewRedisRateLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()})),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testRateLimiter(t, limiter)
+		})
+	}
+}
+
+func testRateLimiter(t *testing.T, limiter RateLimiter) {
+	limit := RateLimit{Burst: 3, Per: 30 * time.Millisecond}
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, err := limiter.Allow("phone", limit)
+		if err != nil || !allowed {
+			t.Fatalf("expected request %d to be allowed, got %v (%v)", i+1, allowed, err)
+		}
+	}
+	if allowed, _ := limiter.Allow("phone", limit); allowed {
+		t.Fatal("expected request over the burst to be refused")
+	}
+
+	// Other keys have their own bucket
+	if allowed, _ := limiter.Allow("other", limit); !allowed {
+		t.Error("expected other key to be allowed")
+	}
+
+	// The bucket refills with time
+	time.Sleep(limit.Per)
+	if allowed, _ := limiter.Allow("phone", limit); !allowed {
+		t.Error("expected request to be allowed after refill")
+	}
+
+	// A zero burst disables the limit
+	for i := 0; i < 5; i++ {
+		if allowed, err := limiter.Allow("disabled", RateLimit{}); err != nil || !allowed {
+			t.Fatalf("expected disabled limit to allow request %d, got %v (%v)", i+1, allowed, err)
+		}
+	}
+}
+
+func TestMemoryRateLimiterKeepsLongLimits(t *testing.T) {
+	limiter := NewMemoryRateLimiter().(*memoryRateLimiter)
+	limit := RateLimit{Burst: 2, Per: 2 * time.Hour}
+
+	for i := 0; i < limit.Burst; i++ {
+		limiter.Allow("phone", limit)
+	}
+
+	// Idle for a while but not long enough to refill, the bucket must survive the sweep
+	limiter.buckets["phone"].updated = limiter.buckets["phone"].updated.Add(-61 * time.Minute)
+	limiter.lastSweep = limiter.lastSweep.Add(-2 * time.Minute)
+
+	if allowed, _ := limiter.Allow("phone", limit); !allowed {
+		t.Fatal("expected the token refilled in an hour to be allowed")
+	}
+	if allowed, _ := limiter.Allow("phone", limit); allowed {
+		t.Error("expected the bucket not to be reset by the sweep")
+	}
+}
+
+func TestRateLimitedRequest(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		opt.RateLimits.PerPhone = RateLimit{Burst: 2, Per: time.Hour}
+	})
+
+	send(t, api, "ATUid_flood_1", "*384#", "")
+	send(t, api, "ATUid_flood_2", "*384#", "")
+	screen := send(t, api, "ATUid_flood_3", "*384#", "")
+	if screen != "END You have made too many requests. Please wait a few minutes and dial again" {
+		t.Errorf("expected rate limited screen, got %q", screen)
+	}
+
+	// Inputs of sessions that already started are not limited
+	for _, text := range []string{"2", "2*1", "2*1*1"} {
+		screen = send(t, api, "ATUid_flood_1", "*384#", text)
+		if !strings.HasPrefix(screen, "CON") {
+			t.Errorf("%s: expected session to continue, got %q", text, screen)
+		}
+	}
+}
+
+func TestExcessiveScreenings(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		opt.RateLimits.MaxScreeningsPerDay = 1
+	})
+
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_screening.golden"))
+	for _, sessionID := range []string{"ATUid_first", "ATUid_second"} {
+		replay(t, api, sessionID, exchanges)
+	}
+
+	screenings := make([]*screening, 0)
+	err := api.sqlDB.Order("id").Find(&screenings).Error
+	if err != nil {
+		t.Fatalf("failed to get screenings: %v", err)
+	}
+	if len(screenings) != 2 || screenings[0].Excessive || !screenings[1].Excessive {
+		t.Fatalf("expected second screening to be flagged, got %d screenings", len(screenings))
+	}
+
+	// Excessive screenings are left out of analytics unless requested
+	admin := newTestAdmin(t, api)
+	for _, c := range []struct {
+		target string
+		want   int
+	}{
+		{target: "/api/ussd/screenings/summary", want: 1},
+		{target: "/api/ussd/screenings/summary?excessive=include", want: 2},
+	} {
+		summary := make([]*screeningSummary, 0)
+		err = json.Unmarshal(adminGet(admin, c.target).Body.Bytes(), &summary)
+		if err != nil || len(summary) != 1 {
+			t.Fatalf("%s: expected 1 summary row, got %d (%v)", c.target, len(summary), err)
+		}
+		if summary[0].Screenings != c.want {
+			t.Errorf("%s: expected %d screenings, got %d", c.target, c.want, summary[0].Screenings)
+		}
+	}
+
+	export := adminGet(admin, "/api/ussd/screenings/export").Body.String()
+	if strings.Contains(export, "\n2,") {
+		t.Errorf("expected excessive screening not to be exported, got %q", export)
+	}
+}
+
+func TestExcessiveScreeningsOnBehalfOfOthers(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		opt.RateLimits.MaxScreeningsPerDay = 1
+	})
+
+	// The members of a household count as one screening of the phone
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_household.golden"))
+	replay(t, api, "ATUid_household", exchanges)
+	replay(t, api, "ATUid_household_again", exchanges)
+
+	screenings := make([]*screening, 0)
+	err := api.sqlDB.Order("id").Find(&screenings).Error
+	if err != nil {
+		t.Fatalf("failed to get screenings: %v", err)
+	}
+	if len(screenings) != 4 || screenings[0].Excessive || screenings[1].Excessive || !screenings[2].Excessive || !screenings[3].Excessive {
+		t.Fatalf("expected the second household to be flagged, got %d screenings", len(screenings))
+	}
+	if screenings[1].PhoneHash != "" || screenings[1].CallerHash != api.hashPhone(testPhone) {
+		t.Errorf("expected only the caller hash on the second member screening, got %+v", screenings[1])
+	}
+
+	// Health workers screen many people a day
+	registerHealthWorker(t, api)
+	questions := readConversation(t, filepath.Join("testdata", "conversations", "en_screening.golden"))[4:]
+	for i := 0; i < 3; i++ {
+		sessionID := fmt.Sprintf("ATUid_worker_%d", i)
+		for _, input := range []string{"", "1", "1*1", "1*1*8", "1*1*8*4321", "1*1*8*4321*1"} {
+			send(t, api, sessionID, "*384#", input)
+		}
+		text := "1*1*8*4321*1*hh-12"
+		screen := send(t, api, sessionID, "*384#", text)
+		for _, ex := range questions {
+			text += "*" + ex.input
+			screen = send(t, api, sessionID, "*384#", text)
+		}
+		if !strings.HasPrefix(screen, "END You have") {
+			t.Fatalf("expected risk result, got %q", screen)
+		}
+	}
+
+	var flagged int
+	api.sqlDB.Model(&screening{}).Where("health_worker_id IS NOT NULL AND excessive = ?", true).Count(&flagged)
+	if flagged != 0 {
+		t.Errorf("expected health worker screenings not to be flagged, got %d", flagged)
+	}
+}
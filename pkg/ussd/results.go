@@ -0,0 +1,304 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Test results
+const (
+	resultPositive = "positive"
+	resultNegative = "negative"
+)
+
+// checkInReminderHour is the hour of the day in East Africa Time from which check-in reminders are sent
+const checkInReminderHour = 8
+
+// testResult is a COVID-19 test result reported by a user
+type testResult struct {
+	ID        uint   `gorm:"primary_key"`
+	SessionID string `gorm:"type:varchar(50);not null"`
+	PhoneHash string `gorm:"type:varchar(64);index"`
+	Language  string `gorm:"type:varchar(5)"`
+	Result    string `gorm:"type:varchar(10);not null"`
+	TestedOn  time.Time
+	Facility  string `gorm:"type:varchar(100)"`
+	// IsolationEnds is set for positive results
+	IsolationEnds *time.Time
+	CreatedAt     time.Time
+}
+
+func (*testResult) TableName() string {
+	return "ussd_test_results"
+}
+
+// checkIn is a daily symptom check-in of a user in isolation or quarantine
+type checkIn struct {
+	ID uint `gorm:"primary_key"`
+	// TestResultID is 0 for users in quarantine after an exposure notification
+	TestResultID uint      `gorm:"index;not null"`
+	PhoneHash    string    `gorm:"type:varchar(64);index"`
+	Due          time.Time `gorm:"index"`
+	RemindedAt   *time.Time
+	CompletedAt  *time.Time
+	// Answers of the check-in
+	Fever     bool
+	Breathing string `gorm:"type:varchar(20)"`
+	Feeling   string `gorm:"type:varchar(20)"`
+	Score     int
+	// Urgent is set when the answers or the change from the previous days need a call from the follow-up team
+	Urgent bool
+}
+
+func (*checkIn) TableName() string {
+	return "ussd_check_ins"
+}
+
+// today returns the start of the day in East Africa Time
+func today(now time.Time) time.Time {
+	year, month, day := now.In(eastAfricaTime).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, eastAfricaTime)
+}
+
+// resultQuestions are asked in the report my test result menu
+func resultQuestions(messages Messages, lang string) []*Question {
+	return []*Question{
+		{
+			ID:   "result",
+			Text: map[string]string{lang: messages.text("result_question", lang)},
+			Options: []*Option{
+				{Text: map[string]string{lang: messages.text("result_positive_option", lang)}, Value: resultPositive},
+				{Text: map[string]string{lang: messages.text("result_negative_option", lang)}, Value: resultNegative},
+			},
+		},
+		{
+			ID:   "tested_days_ago",
+			Type: QuestionInteger,
+			Text: map[string]string{lang: messages.text("result_tested_days_ago", lang)},
+			Min:  0,
+			Max:  30,
+		},
+		{
+			ID:        "facility",
+			Type:      QuestionText,
+			Text:      map[string]string{lang: messages.text("result_facility", lang)},
+			MinLength: 3,
+			MaxLength: 60,
+		},
+	}
+}
+
+// handleTestResult asks for the test result, the test date and the facility then saves the result
+func (api *ussdAPIServer) handleTestResult(ctx context.Context, w *requestLog, ussd *ussdPayload, lang string) (string, error) {
+	var (
+		messages  = ussd.content.Messages
+		questions = resultQuestions(messages, lang)
+		flow      = newInputFlow(ussd, lang, questions[0])
+	)
+
+	flow.replay(serviceInputs(ussd), func(ans *answer, last bool) (string, error) {
+		flow.question = nextQuestion(questions, flow.question)
+		return "", nil
+	}, nil)
+
+	if flow.question != nil {
+		w.stage = "result_" + flow.question.ID
+		return flow.render(), nil
+	}
+
+	w.stage = "result_saved"
+	answers := flow.qc.answers
+	days, _ := strconv.Atoi(answers["tested_days_ago"][0])
+
+	result, err := api.saveTestResult(ussd, lang, answers["result"][0], days, answers["facility"][0])
+	if err != nil {
+		return "", err
+	}
+
+	if result == nil {
+		return "END " + messages.text("result_not_saved", lang), nil
+	}
+	switch {
+	case result.IsolationEnds == nil:
+		return "END " + messages.text("result_negative", lang), nil
+	case !result.IsolationEnds.After(time.Now()):
+		// Tests older than the isolation period are kept but there is nothing left to check on
+		return "END " + fmt.Sprintf(messages.text("result_isolation_over", lang), result.IsolationEnds.Format("02 Jan 2006")), nil
+	}
+	return "END " + fmt.Sprintf(messages.text("result_positive", lang), result.IsolationEnds.Format("02 Jan 2006")), nil
+}
+
+// saveTestResult saves the result and schedules a check-in for every day of isolation of positive results. Nothing
+// is saved if the user declined consent.
+func (api *ussdAPIServer) saveTestResult(ussd *ussdPayload, lang, result string, testedDaysAgo int, facility string) (*testResult, error) {
+	allowed, err := api.healthDataAllowed(ussd.SessionID)
+	if err != nil || !allowed {
+		return nil, err
+	}
+
+	var (
+		now    = time.Now()
+		record = &testResult{
+			SessionID: ussd.SessionID,
+			PhoneHash: api.hashPhone(ussd.PhoneNumber),
+			Language:  lang,
+			Result:    result,
+			TestedOn:  today(now).AddDate(0, 0, -testedDaysAgo),
+			Facility:  facility,
+		}
+	)
+	if result == resultPositive {
+		ends := record.TestedOn.Add(api.isolationPeriod)
+		record.IsolationEnds = &ends
+	}
+
+	err = api.sqlDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(record).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to save test result")
+		}
+		if record.IsolationEnds == nil {
+			return nil
+		}
+		return scheduleCheckIns(tx, record.ID, record.PhoneHash, now, *record.IsolationEnds)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	testResultsTotal.WithLabelValues(result).Inc()
+
+	return record, nil
+}
+
+// scheduleCheckIns adds a check-in for every day from tomorrow until the isolation or quarantine ends. Days that
+// already have a check-in are skipped since a case can also be the contact of another case.
+func scheduleCheckIns(tx *gorm.DB, testResultID uint, phoneHash string, now, ends time.Time) error {
+	existing := make([]*checkIn, 0)
+	err := tx.Find(&existing, "phone_hash = ? AND due > ?", phoneHash, today(now)).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to get scheduled check-ins")
+	}
+	scheduled := make(map[string]bool, len(existing))
+	for _, record := range existing {
+		scheduled[today(record.Due).Format("2006-01-02")] = true
+	}
+
+	for due := today(now).AddDate(0, 0, 1); due.Before(ends); due = due.AddDate(0, 0, 1) {
+		if scheduled[due.Format("2006-01-02")] {
+			continue
+		}
+		err = tx.Create(&checkIn{TestResultID: testResultID, PhoneHash: phoneHash, Due: due}).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to schedule check-in")
+		}
+	}
+	return nil
+}
+
+// activeIsolation returns the positive result of a user still in isolation or nil
+func (api *ussdAPIServer) activeIsolation(phoneHash string, now time.Time) (*testResult, error) {
+	result := &testResult{}
+	err := api.sqlDB.Order("created_at DESC").
+		First(result, "phone_hash = ? AND result = ? AND isolation_ends > ?", phoneHash, resultPositive, now.In(eastAfricaTime)).Error
+	switch {
+	case err == nil:
+		return result, nil
+	case gorm.IsRecordNotFoundError(err):
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to get test result")
+	}
+}
+
+// completeCheckIn marks the check-in of today as done
+func (api *ussdAPIServer) completeCheckIn(result *testResult, now time.Time) error {
+	day := today(now)
+	err := api.sqlDB.Model(&checkIn{}).
+		Where("test_result_id = ? AND due >= ? AND due < ? AND completed_at IS NULL", result.ID, day, day.AddDate(0, 0, 1)).
+		Update("completed_at", now).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to complete check-in")
+	}
+	return nil
+}
+
+// sendCheckInReminders sends an SMS to users in isolation or quarantine whose check-in is due today. Reminders are
+// sent from checkInReminderHour so that users are not woken up. Check-ins missed on earlier days are not reminded.
+func (api *ussdAPIServer) sendCheckInReminders(ctx context.Context, now time.Time) error {
+	now = now.In(eastAfricaTime)
+	if now.Hour() < checkInReminderHour {
+		return nil
+	}
+
+	var rows []struct {
+		ID          uint
+		PhoneHash   string
+		PhoneNumber string
+		Language    string
+	}
+	// Users in quarantine have no test result so the language of their check-in is not known
+	err := api.sqlDB.Table("ussd_check_ins").
+		Select("ussd_check_ins.id, ussd_check_ins.phone_hash, ussd_contacts.phone_number, ussd_test_results.language").
+		Joins("LEFT JOIN ussd_test_results ON ussd_test_results.id = ussd_check_ins.test_result_id").
+		Joins("JOIN ussd_contacts ON ussd_contacts.phone_hash = ussd_check_ins.phone_hash").
+		Where("ussd_check_ins.due >= ? AND ussd_check_ins.due <= ?", today(now), now).
+		Where("ussd_check_ins.reminded_at IS NULL AND ussd_check_ins.completed_at IS NULL").
+		Order("ussd_check_ins.due DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to get due check-ins")
+	}
+
+	var (
+		messages = api.content.current().Messages
+		reminded = make(map[string]bool, len(rows))
+	)
+	for _, row := range rows {
+		// One reminder per phone even if a case and a contact check-in are both due
+		if reminded[row.PhoneHash] {
+			continue
+		}
+		reminded[row.PhoneHash] = true
+
+		message := messages.text("check_in_reminder", row.Language)
+		if row.Language == "" {
+			message = messages.text("check_in_reminder", eng) + "\n" + messages.text("check_in_reminder", swa)
+		}
+		err = api.sms.SendSMS(ctx, row.PhoneNumber, message)
+		if err != nil {
+			api.logger.Warningf("failed to send check-in reminder %d: %v", row.ID, err)
+			continue
+		}
+		err = api.sqlDB.Model(&checkIn{ID: row.ID}).Update("reminded_at", now).Error
+		if err != nil {
+			return errors.Wrap(err, "failed to save check-in reminder")
+		}
+	}
+
+	return nil
+}
+
+// runCheckInReminders sends check-in reminders periodically until the context is cancelled
+func (api *ussdAPIServer) runCheckInReminders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := api.sendCheckInReminders(ctx, time.Now())
+		if err != nil {
+			api.logger.Errorf("failed to send check-in reminders: %v", err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package ussd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPositiveResultSchedulesCheckIns(t *testing.T) {
+	api := newTestAPI(t)
+
+	// The consent screen saves the phone number used for reminders
+	var screen string
+	for _, text := range []string{"", "1", "1*1", "1*1*6", "1*1*6*1", "1*1*6*1*2", "1*1*6*1*2*Kibra Health Centre"} {
+		screen = send(t, api, "ATUid_result", "*384#", text)
+	}
+	if !strings.HasPrefix(screen, "END Thank you for reporting. Stay in isolation until") {
+		t.Fatalf("expected isolation advice, got %q", screen)
+	}
+
+	result := &testResult{}
+	err := api.sqlDB.First(result, "session_id = ?", "ATUid_result").Error
+	if err != nil {
+		t.Fatalf("failed to get test result: %v", err)
+	}
+	if result.Result != resultPositive || result.Facility != "Kibra Health Centre" || result.PhoneHash != api.hashPhone(testPhone) {
+		t.Errorf("unexpected test result %+v", result)
+	}
+
+	// Tested 2 days ago, check-ins start tomorrow and stop when the 14 days of isolation end
+	checkIns := make([]*checkIn, 0)
+	err = api.sqlDB.Order("due").Find(&checkIns, "test_result_id = ?", result.ID).Error
+	if err != nil {
+		t.Fatalf("failed to get check-ins: %v", err)
+	}
+	if len(checkIns) != 11 {
+		t.Fatalf("expected 11 check-ins, got %d", len(checkIns))
+	}
+
+	// Reminders are sent once the check-in is due and not before the reminder hour
+	sms := api.sms.(*fakeSMS)
+	tomorrow := today(time.Now()).AddDate(0, 0, 1)
+	err = api.sendCheckInReminders(context.Background(), tomorrow.Add(6*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to send reminders: %v", err)
+	}
+	if len(sms.messages) != 0 {
+		t.Errorf("expected no reminders before %d:00, got %d", checkInReminderHour, len(sms.messages))
+	}
+	for i := 0; i < 2; i++ {
+		err = api.sendCheckInReminders(context.Background(), tomorrow.Add(9*time.Hour))
+		if err != nil {
+			t.Fatalf("failed to send reminders: %v", err)
+		}
+	}
+	if len(sms.messages) != 1 {
+		t.Errorf("expected 1 reminder, got %d", len(sms.messages))
+	}
+
+	err = api.completeCheckIn(result, tomorrow.Add(10*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to complete check-in: %v", err)
+	}
+	var completed int
+	err = api.sqlDB.Model(&checkIn{}).Where("completed_at IS NOT NULL").Count(&completed).Error
+	if err != nil {
+		t.Fatalf("failed to count check-ins: %v", err)
+	}
+	if completed != 1 {
+		t.Errorf("expected 1 completed check-in, got %d", completed)
+	}
+}
+
+func TestPositiveResultOlderThanIsolation(t *testing.T) {
+	api := newTestAPI(t)
+
+	acceptConsent(t, api, "ATUid_result")
+	screen := send(t, api, "ATUid_result", "*384#", "1*1*6*1*20*Kibra Health Centre")
+	if !strings.HasPrefix(screen, "END Thank you for reporting. Your isolation period ended on") {
+		t.Fatalf("expected isolation to be over, got %q", screen)
+	}
+
+	var checkIns int
+	err := api.sqlDB.Model(&checkIn{}).Count(&checkIns).Error
+	if err != nil {
+		t.Fatalf("failed to count check-ins: %v", err)
+	}
+	if checkIns != 0 {
+		t.Errorf("expected no check-ins after isolation, got %d", checkIns)
+	}
+}
+
+func TestDeclinedConsentKeepsNoHealthData(t *testing.T) {
+	api := newTestAPI(t, func(opt *Options) {
+		opt.FollowUpPhones = []string{"+254700000100"}
+	})
+
+	decline := func(sessionID string) {
+		for _, text := range []string{"", "1", "1*2"} {
+			send(t, api, sessionID, "*384#", text)
+		}
+	}
+
+	decline("ATUid_declined_result")
+	screen := send(t, api, "ATUid_declined_result", "*384#", "1*2*6*1*0*Kibra Health Centre")
+	if !strings.HasPrefix(screen, "END Thank you. Your result was not saved") {
+		t.Errorf("expected result not to be saved, got %q", screen)
+	}
+	var results int
+	api.sqlDB.Model(&testResult{}).Count(&results)
+	if results != 0 {
+		t.Fatalf("expected no test results, got %d", results)
+	}
+
+	// A result reported with consent in an earlier session starts the check-ins
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// Fever, very hard breathing and very unwell
+	decline("ATUid_declined_check_in")
+	var screens []string
+	for _, text := range []string{"1*2*1", "1*2*1*1", "1*2*1*1*3", "1*2*1*1*3*3"} {
+		screens = append(screens, send(t, api, "ATUid_declined_check_in", "*384#", text))
+	}
+	if screen := screens[len(screens)-1]; screen != "END Your symptoms are getting worse. CALL NOW: 0732353535" {
+		t.Errorf("expected call now screen without follow-up, got %q", screen)
+	}
+	var completed int
+	api.sqlDB.Model(&checkIn{}).Where("completed_at IS NOT NULL").Count(&completed)
+	if completed != 0 {
+		t.Errorf("expected no completed check-ins, got %d", completed)
+	}
+	if sms := api.sms.(*fakeSMS); len(sms.messages) != 0 {
+		t.Errorf("expected no follow-up team alert, got %q", sms.messages)
+	}
+
+	decline("ATUid_declined_notify")
+	screen = send(t, api, "ATUid_declined_notify", "*384#", "1*2*7*1*0712000001*2")
+	if !strings.HasPrefix(screen, "END Notifying contacts needs your consent") {
+		t.Errorf("expected notification to need consent, got %q", screen)
+	}
+	var exposures int
+	api.sqlDB.Model(&exposure{}).Count(&exposures)
+	if exposures != 0 {
+		t.Errorf("expected no exposures, got %d", exposures)
+	}
+}
+
+func TestQuarantineSchedulesCheckIns(t *testing.T) {
+	api := newTestAPI(t)
+	acceptConsent(t, api, "ATUid_result")
+	send(t, api, "ATUid_result", "*384#", "1*1*6*1*0*Kibra Health Centre")
+
+	// The contact used the service before so the phone number is known
+	contactPhone := "+254712000001"
+	err := api.saveContact(contactPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acceptConsent(t, api, "ATUid_notify")
+	send(t, api, "ATUid_notify", "*384#", "1*1*7*1*0712000001*2")
+
+	checkIns := make([]*checkIn, 0)
+	err = api.sqlDB.Find(&checkIns, "phone_hash = ?", api.hashPhone(contactPhone)).Error
+	if err != nil {
+		t.Fatalf("failed to get check-ins: %v", err)
+	}
+	if len(checkIns) < 13 || checkIns[0].TestResultID != 0 {
+		t.Fatalf("expected quarantine check-ins for every day, got %d", len(checkIns))
+	}
+
+	// A check-in missed today is not reminded tomorrow
+	err = api.sqlDB.Create(&checkIn{PhoneHash: api.hashPhone(testPhone), Due: today(time.Now())}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sms := api.sms.(*fakeSMS)
+	sms.messages = nil
+	tomorrow := today(time.Now()).AddDate(0, 0, 1)
+	err = api.sendCheckInReminders(context.Background(), tomorrow.Add(9*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to send reminders: %v", err)
+	}
+	if len(sms.messages) != 2 {
+		t.Fatalf("expected one reminder for the case and one for the contact, got %q", sms.messages)
+	}
+	// The language of the contact is not known
+	reminders := strings.Join(sms.messages, "\n")
+	if strings.Count(reminders, "how are you feeling today") != 2 || strings.Count(reminders, "unajisikiaje leo") != 1 {
+		t.Errorf("expected the contact reminder in both languages, got %q", sms.messages)
+	}
+}
@@ -0,0 +1,149 @@
+package ussd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (api *ussdAPIServer) riskAnalysis(ussd *ussdPayload) (string, error) {
+	lang, err := api.getUserLanguage(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user language")
+	}
+
+	risk, err := api.getRisk(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user risk")
+	}
+
+	session, err := api.getUserFromSession(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user session")
+	}
+
+	var (
+		now       = time.Now()
+		phoneHash = api.hashPhone(ussd.PhoneNumber)
+		isolation *testResult
+		exposure  *exposure
+		record    = &screening{}
+	)
+
+	switch session[modeKey] {
+	case modeHealthWorker:
+		workerID, err := strconv.Atoi(session[workerKey])
+		if err != nil {
+			return "", errors.Wrap(err, "invalid health worker in session")
+		}
+		id := uint(workerID)
+		record.HealthWorkerID = &id
+		record.HouseholdID = session[householdKey]
+	case modeHousehold:
+		record.Relationship = session[relationshipKey]
+	}
+
+	// Screenings on behalf of someone else are not about the owner of the phone
+	if !screenedOnBehalf(session) {
+		// Users who reported a positive test are high risk whatever their answers. Their own screenings are
+		// replaced by the check-in so only household screenings of the phone owner get here in isolation.
+		isolation, err = api.activeIsolation(phoneHash, now)
+		if err != nil {
+			return "", err
+		}
+
+		// Users reported as close contacts are at least medium risk
+		exposure, err = api.pendingExposure(phoneHash, now)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	band := riskBand(risk)
+	switch {
+	case isolation != nil:
+		band = riskHigh
+	case exposure != nil && band == riskLow:
+		band = riskMedium
+	}
+	riskResultsTotal.WithLabelValues(band, ussd.content.Questionnaire.Version).Inc()
+
+	record.RiskBand = band
+	record.Isolating = isolation != nil
+	record.KnownExposure = exposure != nil
+	err = api.saveScreening(ussd, record)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save screening")
+	}
+
+	// Only the first screening after the notification is flagged
+	if exposure != nil && record.ID != 0 {
+		err = api.sqlDB.Model(exposure).Update("screening_id", record.ID).Error
+		if err != nil {
+			return "", errors.Wrap(err, "failed to link exposure to screening")
+		}
+	}
+
+	messages := ussd.content.Messages
+
+	if isolation != nil {
+		err = api.completeCheckIn(isolation, now)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if session[modeKey] == modeHousehold {
+		return api.memberScreened(ussd, session, band, lang)
+	}
+
+	recommendations := messages.list("recommendations", lang)
+	if len(recommendations) > 3 {
+		recommendations = recommendations[:3]
+	}
+
+	response := "END " + fmt.Sprintf(messages.text("risk_result", lang), messages.text("risk_"+band, lang)) + "\n"
+	if exposure != nil {
+		response += messages.text("risk_exposure", lang) + "\n"
+	}
+	for index, recommendation := range recommendations {
+		response += fmt.Sprintf("%d. %s\n", index+1, recommendation)
+	}
+	if band == riskHigh {
+		response += "\n" + messages.text("risk_find_facility", lang) + "\n"
+	}
+	response += "\n" + messages.text("risk_closing", lang)
+
+	return response, nil
+}
+
+const (
+	riskHigh   = "HIGH"
+	riskMedium = "MEDIUM"
+	riskLow    = "LOW"
+)
+
+func riskBand(risk int) string {
+	switch {
+	case risk >= 10:
+		return riskHigh
+	case risk > 5:
+		return riskMedium
+	default:
+		return riskLow
+	}
+}
+
+func (api *ussdAPIServer) getRisk(userID string) (int, error) {
+	riskStr, err := api.sessions.Get(userID, scoreKey)
+	switch {
+	case err == errSessionValueNotFound:
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	return strconv.Atoi(riskStr)
+}
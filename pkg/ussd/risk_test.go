@@ -0,0 +1,23 @@
+package ussd
+
+import "testing"
+
+func TestRiskBand(t *testing.T) {
+	cases := []struct {
+		risk int
+		band string
+	}{
+		{risk: 0, band: riskLow},
+		{risk: 5, band: riskLow},
+		{risk: 6, band: riskMedium},
+		{risk: 9, band: riskMedium},
+		{risk: 10, band: riskHigh},
+		{risk: 11, band: riskHigh},
+	}
+
+	for _, c := range cases {
+		if band := riskBand(c.risk); band != c.band {
+			t.Errorf("expected risk %d to be %s, got %s", c.risk, c.band, band)
+		}
+	}
+}
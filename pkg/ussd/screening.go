@@ -0,0 +1,312 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// questionKey is the session key holding the id of the question the user is answering
+	questionKey = "question"
+	// answerKeyPrefix prefixes session keys holding answers
+	answerKeyPrefix = "answer:"
+	// questionnaireKey is the session key holding the version of the questionnaire the screening started with
+	questionnaireKey = "questionnaire"
+	// pageKey is the session key holding the page of options shown for the current question
+	pageKey = "page"
+)
+
+// screening is the analytics record of a completed self-screening. Users are referenced by phone hash only.
+type screening struct {
+	ID                   uint   `gorm:"primary_key"`
+	SessionID            string `gorm:"type:varchar(50);not null"`
+	PhoneHash            string `gorm:"type:varchar(64);index"`
+	QuestionnaireVersion string `gorm:"type:varchar(20);index"`
+	Language             string `gorm:"type:varchar(5)"`
+	County               string `gorm:"type:varchar(50);index"`
+	SubCounty            string `gorm:"type:varchar(50)"`
+	RiskScore            int
+	RiskBand             string `gorm:"type:varchar(10)"`
+	// Isolating is set when the user had reported a positive test and was still in isolation
+	Isolating bool
+	// KnownExposure is set when a confirmed case had reported the user as a close contact
+	KnownExposure bool
+	// HealthWorkerID and HouseholdID are set for screenings done by a health worker on behalf of someone else
+	HealthWorkerID *uint  `gorm:"index"`
+	HouseholdID    string `gorm:"type:varchar(20);index"`
+	// Relationship is set for household screenings, e.g self or child
+	Relationship string `gorm:"type:varchar(10)"`
+	// CallerHash is the phone the screening was done from, also for screenings on behalf of someone else. It is
+	// only used to flag excessive screenings.
+	CallerHash string `gorm:"type:varchar(64);index"`
+	// Excessive is set when the phone did more than the daily limit of screenings. Excessive screenings are
+	// excluded from analytics.
+	Excessive bool               `gorm:"index"`
+	Answers   []*screeningAnswer `gorm:"foreignkey:ScreeningID"`
+	CreatedAt time.Time
+}
+
+func (*screening) TableName() string {
+	return "ussd_screenings"
+}
+
+// screeningAnswer is the answer to a single question of a screening
+type screeningAnswer struct {
+	ID          uint   `gorm:"primary_key"`
+	ScreeningID uint   `gorm:"index;not null"`
+	QuestionID  string `gorm:"type:varchar(50);not null"`
+	Type        string `gorm:"type:varchar(10)"`
+	Value       string `gorm:"type:varchar(256)"`
+	// Number is set for integer and number questions so that answers can be aggregated in SQL
+	Number *float64
+}
+
+func (*screeningAnswer) TableName() string {
+	return "ussd_screening_answers"
+}
+
+// handleScreening moves the user through the questionnaire. The last input of the text answers the question
+// saved in the session.
+func (api *ussdAPIServer) handleScreening(ctx context.Context, w *requestLog, ussd *ussdPayload) (string, error) {
+	lang, err := api.getUserLanguage(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user language")
+	}
+
+	questionnaire := ussd.content.Questionnaire
+
+	// Start of screening
+	if strings.Count(ussd.Text, "*") == 1 {
+		// Users in isolation or quarantine take the daily check-in instead
+		followUp, err := api.underFollowUp(api.hashPhone(ussd.PhoneNumber), time.Now())
+		if err != nil {
+			return "", err
+		}
+		if followUp {
+			err = api.sessions.Set(ussd.SessionID, modeKey, modeCheckIn)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to start check-in")
+			}
+			return api.handleCheckIn(ctx, w, ussd, lang)
+		}
+
+		w.stage = "screening_start"
+		first := questionnaire.Questions[0]
+		err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+			questionKey:      first.ID,
+			questionnaireKey: questionnaire.Version,
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to start screening")
+		}
+		return questionnaire.render(first, api.questionContext(ussd, nil), lang, "", true), nil
+	}
+
+	session, err := api.getUserFromSession(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user session")
+	}
+
+	if session[modeKey] == modeCheckIn {
+		return api.handleCheckIn(ctx, w, ussd, lang)
+	}
+
+	// The version the session started with is no longer kept
+	if session[questionnaireKey] != questionnaire.Version {
+		w.stage = "screening_restart"
+		return "END " + ussd.content.Messages.text("questionnaire_changed", lang), nil
+	}
+
+	questionID := session[questionKey]
+
+	question, _ := questionnaire.question(questionID)
+	if question == nil {
+		return "", errors.Errorf("unknown question %q", questionID)
+	}
+	w.stage = question.ID
+
+	var (
+		qc    = api.questionContext(ussd, session)
+		input = ussd.Text[strings.LastIndex(ussd.Text, "*")+1:]
+	)
+
+	page, ok := question.turnPage(qc, input)
+	if ok {
+		w.stage = question.ID + "_page"
+		err = api.sessions.Set(ussd.SessionID, pageKey, strconv.Itoa(page))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to save page")
+		}
+		qc.page = page
+		return questionnaire.render(question, qc, lang, "", false), nil
+	}
+
+	if question.kind() == QuestionCounty {
+		qc.cases, err = api.recentCaseCounts(time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ans, invalid := question.parse(qc, input)
+	if invalid != nil {
+		w.stage = question.ID + "_invalid"
+		return questionnaire.render(question, qc, lang, invalid.text(ussd.content.Messages, lang), false), nil
+	}
+
+	err = api.saveAnswer(ussd.SessionID, question, ans)
+	if err != nil {
+		return "", err
+	}
+
+	qc.answers[question.ID] = ans.values
+	qc.page = 0
+
+	next := questionnaire.next(question, ans, qc.answers)
+	if next == nil {
+		return api.riskAnalysis(ussd)
+	}
+
+	err = api.sessions.SetAll(ussd.SessionID, map[string]string{
+		questionKey: next.ID,
+		pageKey:     "0",
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save current question")
+	}
+
+	return questionnaire.render(next, qc, lang, "", false), nil
+}
+
+// questionContext returns what the questions of the session depend on
+func (api *ussdAPIServer) questionContext(ussd *ussdPayload, session map[string]string) *questionContext {
+	page, _ := strconv.Atoi(session[pageKey])
+	return &questionContext{
+		answers:   sessionAnswers(session),
+		locations: ussd.content.Locations,
+		messages:  ussd.content.Messages,
+		page:      page,
+	}
+}
+
+func (api *ussdAPIServer) saveAnswer(userID string, question *Question, ans *answer) error {
+	if len(ans.values) == 0 {
+		return nil
+	}
+
+	_, err := api.sessions.IncrementScore(userID, ans.score)
+	if err != nil {
+		return errors.Wrap(err, "failed to save user score")
+	}
+
+	// Number answers are not labelled to keep the metric small
+	for _, option := range ans.options {
+		answersTotal.WithLabelValues(question.ID, option.Value).Inc()
+	}
+
+	// Values are JSON encoded since text answers can contain commas
+	values, err := json.Marshal(ans.values)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode answer for %s", question.ID)
+	}
+
+	err = api.sessions.Set(userID, answerKeyPrefix+question.ID, string(values))
+	if err != nil {
+		return errors.Wrapf(err, "failed to save answer for %s", question.ID)
+	}
+
+	return nil
+}
+
+// sessionAnswers returns the answers saved in the session by question id
+func sessionAnswers(session map[string]string) map[string][]string {
+	answers := make(map[string][]string)
+	for key, value := range session {
+		if !strings.HasPrefix(key, answerKeyPrefix) {
+			continue
+		}
+		var values []string
+		if json.Unmarshal([]byte(value), &values) == nil {
+			answers[strings.TrimPrefix(key, answerKeyPrefix)] = values
+		}
+	}
+	return answers
+}
+
+// saveScreening saves the answers of the session with the outcome of the risk analysis. The record is only
+// created if the user consented.
+func (api *ussdAPIServer) saveScreening(ussd *ussdPayload, record *screening) error {
+	allowed, err := api.healthDataAllowed(ussd.SessionID)
+	if err != nil || !allowed {
+		return err
+	}
+
+	session, err := api.getUserFromSession(ussd.SessionID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user session")
+	}
+
+	riskScore, _ := strconv.Atoi(session[scoreKey])
+
+	questions := ussd.content.Questionnaire.questions()
+	record.SessionID = ussd.SessionID
+	record.CallerHash = session["phoneHash"]
+	if !screenedOnBehalf(session) {
+		record.PhoneHash = session["phoneHash"]
+	}
+
+	if record.HealthWorkerID == nil {
+		record.Excessive, err = api.excessiveScreening(record.CallerHash, ussd.SessionID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if record.Excessive {
+		excessiveScreeningsTotal.Inc()
+		api.logger.Warningf("screening from %s flagged as excessive", record.CallerHash)
+	}
+	record.QuestionnaireVersion = session[questionnaireKey]
+	record.Language = session["lang"]
+	record.RiskScore = riskScore
+
+	var (
+		values  = sessionAnswers(session)
+		answers = make([]*screeningAnswer, 0, len(questions))
+	)
+	for _, question := range questions {
+		answered, ok := values[question.ID]
+		if !ok {
+			continue
+		}
+		value := strings.Join(answered, ",")
+		answer := &screeningAnswer{QuestionID: question.ID, Type: question.kind(), Value: value}
+		if answer.Type == QuestionInteger || answer.Type == QuestionNumber {
+			number, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				answer.Number = &number
+			}
+		}
+		answers = append(answers, answer)
+
+		switch question.kind() {
+		case QuestionCounty:
+			record.County = value
+		case QuestionSubCounty:
+			record.SubCounty = value
+		}
+	}
+
+	record.Answers = answers
+
+	err = api.sqlDB.Create(record).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to save screening")
+	}
+
+	return nil
+}
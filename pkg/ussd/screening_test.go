@@ -0,0 +1,75 @@
+package ussd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestScreeningIsSaved(t *testing.T) {
+	api := newTestAPI(t)
+
+	exchanges := readConversation(t, filepath.Join("testdata", "conversations", "en_screening.golden"))
+	replay(t, api, "ATUid_saved", exchanges)
+
+	var saved screening
+	err := api.sqlDB.Preload("Answers").First(&saved, "session_id = ?", "ATUid_saved").Error
+	if err != nil {
+		t.Fatalf("failed to get screening: %v", err)
+	}
+
+	if saved.PhoneHash != api.hashPhone(testPhone) {
+		t.Errorf("expected screening to reference phone hash, got %q", saved.PhoneHash)
+	}
+	if saved.RiskBand != riskHigh {
+		t.Errorf("expected risk band %s, got %s", riskHigh, saved.RiskBand)
+	}
+
+	if saved.County != "Nairobi" || saved.SubCounty != "Kibra" {
+		t.Errorf("expected screening location Nairobi/Kibra, got %s/%s", saved.County, saved.SubCounty)
+	}
+
+	answers := make(map[string]string, len(saved.Answers))
+	for _, answer := range saved.Answers {
+		answers[answer.QuestionID] = answer.Value
+		if answer.QuestionID == "temperature" && (answer.Number == nil || *answer.Number != 38.5) {
+			t.Errorf("expected temperature to be stored as number 38.5, got %v", answer.Number)
+		}
+	}
+
+	expected := map[string]string{
+		"age":               "65",
+		"county":            "Nairobi",
+		"sub_county":        "Kibra",
+		"contact":           "yes",
+		"contact_how":       "face to face contact within 1 meter,living in the same environment",
+		"symptoms":          "difficulty in breathing,cough,fever",
+		"symptoms_onset":    "3",
+		"symptoms_severity": "moderate",
+		"temperature":       "38.5",
+		"illness":           "asthmatic,hyper tension",
+	}
+	for questionID, value := range expected {
+		if answers[questionID] != value {
+			t.Errorf("expected answer %q for %s, got %q", value, questionID, answers[questionID])
+		}
+	}
+}
+
+func TestTextAnswerWithCommasIsKept(t *testing.T) {
+	api := newTestAPI(t)
+
+	question := &Question{ID: "occupation", Type: QuestionText}
+	err := api.saveAnswer("ATUid_text", question, &answer{values: []string{"Nurse, Kibra Health Centre"}})
+	if err != nil {
+		t.Fatalf("failed to save answer: %v", err)
+	}
+
+	session, err := api.sessions.GetAll("ATUid_text")
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	values := sessionAnswers(session)["occupation"]
+	if len(values) != 1 || values[0] != "Nurse, Kibra Health Centre" {
+		t.Errorf("expected one text answer, got %q", values)
+	}
+}
@@ -0,0 +1,269 @@
+package ussd
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// errSessionValueNotFound is returned when a session or a session value does not exist
+var errSessionValueNotFound = errors.New("session value not found")
+
+// scoreKey is the session key holding the accumulated risk score
+const scoreKey = "risk"
+
+// SessionStore keeps the state of USSD sessions
+type SessionStore interface {
+	// Get returns errSessionValueNotFound if the session or key does not exist
+	Get(sessionID, key string) (string, error)
+	GetAll(sessionID string) (map[string]string, error)
+	Set(sessionID, key, value string) error
+	SetAll(sessionID string, values map[string]string) error
+	// IncrementScore adds to the session risk score and returns the new score
+	IncrementScore(sessionID string, score int) (int, error)
+	// Expire removes the session after the ttl
+	Expire(sessionID string, ttl time.Duration) error
+	Delete(sessionID string) error
+	// DeleteKeys removes values from the session and keeps the others
+	DeleteKeys(sessionID string, keys ...string) error
+	// WithContext returns a store whose calls are cancelled with the context
+	WithContext(ctx context.Context) SessionStore
+}
+
+type redisSessionStore struct {
+	client *redis.Client
+	// ctx is the context of the request the calls are timed for
+	ctx context.Context
+}
+
+// NewRedisSessionStore creates a session store backed by redis hashes
+func NewRedisSessionStore(client *redis.Client) SessionStore {
+	return &redisSessionStore{client: client, ctx: context.Background()}
+}
+
+func (store *redisSessionStore) WithContext(ctx context.Context) SessionStore {
+	return &redisSessionStore{client: store.client.WithContext(ctx), ctx: ctx}
+}
+
+func (store *redisSessionStore) Get(sessionID, key string) (string, error) {
+	defer observeRedis(store.ctx, "hget", time.Now())
+	val, err := store.client.HGet(sessionID, key).Result()
+	switch {
+	case err == redis.Nil:
+		return "", errSessionValueNotFound
+	case err != nil:
+		return "", errors.Wrap(err, "failed to get session value")
+	}
+	return val, nil
+}
+
+func (store *redisSessionStore) GetAll(sessionID string) (map[string]string, error) {
+	defer observeRedis(store.ctx, "hgetall", time.Now())
+	values, err := store.client.HGetAll(sessionID).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get session")
+	}
+	return values, nil
+}
+
+func (store *redisSessionStore) Set(sessionID, key, value string) error {
+	defer observeRedis(store.ctx, "hset", time.Now())
+	return errors.Wrap(store.client.HSet(sessionID, key, value).Err(), "failed to set session value")
+}
+
+func (store *redisSessionStore) SetAll(sessionID string, values map[string]string) error {
+	defer observeRedis(store.ctx, "hmset", time.Now())
+	fields := make(map[string]interface{}, len(values))
+	for key, val := range values {
+		fields[key] = val
+	}
+	return errors.Wrap(store.client.HMSet(sessionID, fields).Err(), "failed to set session values")
+}
+
+func (store *redisSessionStore) IncrementScore(sessionID string, score int) (int, error) {
+	defer observeRedis(store.ctx, "hincrby", time.Now())
+	val, err := store.client.HIncrBy(sessionID, scoreKey, int64(score)).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to increment session score")
+	}
+	return int(val), nil
+}
+
+func (store *redisSessionStore) Expire(sessionID string, ttl time.Duration) error {
+	defer observeRedis(store.ctx, "expire", time.Now())
+	return errors.Wrap(store.client.Expire(sessionID, ttl).Err(), "failed to set session ttl")
+}
+
+func (store *redisSessionStore) Delete(sessionID string) error {
+	defer observeRedis(store.ctx, "del", time.Now())
+	return errors.Wrap(store.client.Del(sessionID).Err(), "failed to delete session")
+}
+
+func (store *redisSessionStore) DeleteKeys(sessionID string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	defer observeRedis(store.ctx, "hdel", time.Now())
+	return errors.Wrap(store.client.HDel(sessionID, keys...).Err(), "failed to delete session values")
+}
+
+type memorySession struct {
+	values    map[string]string
+	expiresAt time.Time
+}
+
+func (session *memorySession) expired(now time.Time) bool {
+	return !session.expiresAt.IsZero() && now.After(session.expiresAt)
+}
+
+type memorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*memorySession
+	lastSweep time.Time
+}
+
+// NewMemorySessionStore creates a session store that keeps sessions in process memory.
+// It is meant for single instance deployments and tests.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions:  make(map[string]*memorySession),
+		lastSweep: time.Now(),
+	}
+}
+
+// WithContext returns the store itself, memory calls don't block
+func (store *memorySessionStore) WithContext(ctx context.Context) SessionStore {
+	return store
+}
+
+// session returns the session, creating it if create is true. Must be called with the lock held.
+func (store *memorySessionStore) session(sessionID string, create bool) *memorySession {
+	now := time.Now()
+
+	// Remove expired sessions once in a while so that abandoned sessions don't pile up
+	if now.Sub(store.lastSweep) > time.Minute {
+		for id, session := range store.sessions {
+			if session.expired(now) {
+				delete(store.sessions, id)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	session, ok := store.sessions[sessionID]
+	if ok && !session.expired(now) {
+		return session
+	}
+	delete(store.sessions, sessionID)
+
+	if !create {
+		return nil
+	}
+
+	session = &memorySession{values: make(map[string]string)}
+	store.sessions[sessionID] = session
+
+	return session
+}
+
+func (store *memorySessionStore) Get(sessionID, key string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session := store.session(sessionID, false)
+	if session == nil {
+		return "", errSessionValueNotFound
+	}
+	val, ok := session.values[key]
+	if !ok {
+		return "", errSessionValueNotFound
+	}
+	return val, nil
+}
+
+func (store *memorySessionStore) GetAll(sessionID string) (map[string]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	values := make(map[string]string)
+	if session := store.session(sessionID, false); session != nil {
+		for key, val := range session.values {
+			values[key] = val
+		}
+	}
+	return values, nil
+}
+
+func (store *memorySessionStore) Set(sessionID, key, value string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.session(sessionID, true).values[key] = value
+	return nil
+}
+
+func (store *memorySessionStore) SetAll(sessionID string, values map[string]string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session := store.session(sessionID, true)
+	for key, val := range values {
+		session.values[key] = val
+	}
+	return nil
+}
+
+func (store *memorySessionStore) IncrementScore(sessionID string, score int) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session := store.session(sessionID, true)
+
+	current := 0
+	if val, ok := session.values[scoreKey]; ok {
+		var err error
+		current, err = strconv.Atoi(val)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to parse session score")
+		}
+	}
+
+	current += score
+	session.values[scoreKey] = strconv.Itoa(current)
+
+	return current, nil
+}
+
+func (store *memorySessionStore) Expire(sessionID string, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if session := store.session(sessionID, false); session != nil {
+		session.expiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (store *memorySessionStore) Delete(sessionID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.sessions, sessionID)
+	return nil
+}
+
+func (store *memorySessionStore) DeleteKeys(sessionID string, keys ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if session := store.session(sessionID, false); session != nil {
+		for _, key := range keys {
+			delete(session.values, key)
+		}
+	}
+	return nil
+}
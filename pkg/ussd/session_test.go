@@ -0,0 +1,124 @@
+package ussd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+func TestSessionStores(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start redis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	for _, c := range []struct {
+		name  string
+		store SessionStore
+		// wait lets the time of the store pass
+		wait func(d time.Duration)
+	}{
+		{name: "memory", store: NewMemorySessionStore(), wait: time.Sleep},
+		{name: "redis", store: NewRedisSessionStore(redis.NewClient(&redis.Options{Addr: mr.Addr()})), wait: mr.FastForward},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			testSessionStore(t, c.store, c.wait)
+		})
+	}
+}
+
+func testSessionStore(t *testing.T, store SessionStore, wait func(d time.Duration)) {
+	_, err := store.Get("session", "lang")
+	if err != errSessionValueNotFound {
+		t.Fatalf("expected errSessionValueNotFound for missing session, got %v", err)
+	}
+
+	err = store.SetAll("session", map[string]string{"lang": eng, "phone": testPhone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lang, err := store.Get("session", "lang")
+	if err != nil || lang != eng {
+		t.Fatalf("expected lang %q, got %q (%v)", eng, lang, err)
+	}
+
+	for _, score := range []int{1, 2, 3} {
+		_, err = store.IncrementScore("session", score)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	risk, err := store.Get("session", scoreKey)
+	if err != nil || risk != "6" {
+		t.Fatalf("expected score 6, got %q (%v)", risk, err)
+	}
+
+	err = store.Expire("session", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(5 * time.Millisecond)
+
+	values, err := store.GetAll("session")
+	if err != nil || len(values) != 0 {
+		t.Fatalf("expected expired session to be empty, got %v (%v)", values, err)
+	}
+
+	err = store.SetAll("other", map[string]string{"lang": swa, scoreKey: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DeleteKeys("other", scoreKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = store.GetAll("other")
+	if err != nil || len(values) != 1 || values["lang"] != swa {
+		t.Fatalf("expected only lang to be kept, got %v (%v)", values, err)
+	}
+	err = store.Delete("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get("other", "lang")
+	if err != errSessionValueNotFound {
+		t.Fatalf("expected deleted session to be gone, got %v", err)
+	}
+}
+
+func TestSessionKeepsPhoneHashUntilConsent(t *testing.T) {
+	api := newTestAPI(t)
+
+	for _, c := range []struct {
+		sessionID string
+		consent   string
+		phone     string
+	}{
+		{sessionID: "ATUid_declined", consent: "2"},
+		{sessionID: "ATUid_accepted", consent: "1", phone: testPhone},
+	} {
+		send(t, api, c.sessionID, "*384#", "")
+		send(t, api, c.sessionID, "*384#", "1")
+		session, err := api.sessions.GetAll(c.sessionID)
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if _, ok := session["phone"]; ok || session["phoneHash"] != api.hashPhone(testPhone) {
+			t.Errorf("%s: expected only the phone hash before consent, got %v", c.sessionID, session)
+		}
+
+		send(t, api, c.sessionID, "*384#", "1*"+c.consent)
+		session, err = api.sessions.GetAll(c.sessionID)
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if session["phone"] != c.phone {
+			t.Errorf("%s: expected phone %q after consent, got %q", c.sessionID, c.phone, session["phone"])
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package ussd
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/grpclog"
+)
+
+// SMSSender sends text messages to users
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone, message string) error
+}
+
+const (
+	africasTalkingSMSURL        = "https://api.africastalking.com/version1/messaging"
+	africasTalkingSandboxSMSURL = "https://api.sandbox.africastalking.com/version1/messaging"
+)
+
+// africasTalkingSMS sends messages through Africa's Talking bulk SMS API
+type africasTalkingSMS struct {
+	username string
+	apiKey   string
+	senderID string
+	client   *http.Client
+}
+
+// NewAfricasTalkingSMS creates an SMSSender that uses Africa's Talking. The "sandbox" username uses the sandbox API.
+func NewAfricasTalkingSMS(username, apiKey, senderID string) SMSSender {
+	return &africasTalkingSMS{
+		username: username,
+		apiKey:   apiKey,
+		senderID: senderID,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (sms *africasTalkingSMS) SendSMS(ctx context.Context, phone, message string) error {
+	form := url.Values{}
+	form.Set("username", sms.username)
+	form.Set("to", phone)
+	form.Set("message", message)
+	if sms.senderID != "" {
+		form.Set("from", sms.senderID)
+	}
+
+	endpoint := africasTalkingSMSURL
+	if sms.username == "sandbox" {
+		endpoint = africasTalkingSandboxSMSURL
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create sms request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("apiKey", sms.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := sms.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send sms")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return errors.Errorf("sms gateway responded with status %d: %s", res.StatusCode, body)
+	}
+
+	return nil
+}
+
+const (
+	// smsTimeout bounds a message of a USSD request. It is longer than the request deadline since the SMS gateway
+	// can take several seconds to answer.
+	smsTimeout = 15 * time.Second
+	// smsWorkers is the number of messages sent at the same time
+	smsWorkers = 4
+	// smsQueueSize is the number of messages that can wait for a worker before new messages are refused
+	smsQueueSize = 1000
+)
+
+// errSMSQueueFull is returned when the SMS gateway can't keep up with the messages of the requests
+var errSMSQueueFull = errors.New("sms queue is full")
+
+// detachedContext keeps the values of a context without its deadline and cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// smsJob is a message waiting to be sent
+type smsJob struct {
+	ctx     context.Context
+	phone   string
+	message string
+	// sent is called with the result of the send when it is set
+	sent func(err error)
+}
+
+// smsQueue sends the messages of USSD requests in the background so that the reply to the gateway doesn't wait for
+// the SMS gateway. It is bounded so that a slow SMS gateway can't pile up messages.
+type smsQueue struct {
+	sender  SMSSender
+	logger  grpclog.LoggerV2
+	jobs    chan *smsJob
+	pending sync.WaitGroup
+}
+
+func newSMSQueue(ctx context.Context, sender SMSSender, logger grpclog.LoggerV2) *smsQueue {
+	queue := &smsQueue{
+		sender: sender,
+		logger: logger,
+		jobs:   make(chan *smsJob, smsQueueSize),
+	}
+	for i := 0; i < smsWorkers; i++ {
+		go queue.run(ctx)
+	}
+	return queue
+}
+
+func (queue *smsQueue) push(job *smsJob) error {
+	queue.pending.Add(1)
+	select {
+	case queue.jobs <- job:
+		return nil
+	default:
+		queue.pending.Done()
+		return errSMSQueueFull
+	}
+}
+
+func (queue *smsQueue) run(ctx context.Context) {
+	for {
+		select {
+		case job := <-queue.jobs:
+			queue.send(job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (queue *smsQueue) send(job *smsJob) {
+	defer queue.pending.Done()
+
+	ctx, cancel := context.WithTimeout(job.ctx, smsTimeout)
+	defer cancel()
+
+	err := queue.sender.SendSMS(ctx, job.phone, job.message)
+	if job.sent != nil {
+		job.sent(err)
+		return
+	}
+	if err != nil {
+		queue.logger.Errorf("failed to send sms: %v", err)
+	}
+}
+
+// wait returns once the queued messages are sent
+func (queue *smsQueue) wait() {
+	queue.pending.Wait()
+}
+
+// queueSMS sends a message of a USSD request in the background so that the reply doesn't wait for it. The message
+// keeps the values of the request but not its deadline. sent is called with the result of the send when it is set.
+func (api *ussdAPIServer) queueSMS(ctx context.Context, phone, message string, sent func(err error)) error {
+	return api.smsQueue.push(&smsJob{ctx: detachedContext{ctx}, phone: phone, message: message, sent: sent})
+}
+
+// logSMS only logs messages. It is used when no SMS gateway is configured.
+type logSMS struct {
+	logger grpclog.LoggerV2
+}
+
+func (sms *logSMS) SendSMS(ctx context.Context, phone, message string) error {
+	sms.logger.Infof("sms gateway not configured, dropping message of %d characters", len(message))
+	return nil
+}
@@ -0,0 +1,108 @@
+package ussd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	eng = "en"
+	swa = "sw"
+)
+
+// servicesPageSize is the number of services on a page of the services menu. Longer pages don't fit on the screen
+// in Swahili.
+const servicesPageSize = 4
+
+func (api *ussdAPIServer) saveUser(ussd *ussdPayload) error {
+	values := map[string]string{
+		"phoneHash": api.hashPhone(ussd.PhoneNumber),
+		contentKey:  ussd.content.Version,
+	}
+
+	// Phone numbers are only kept for users who consented
+	if !api.consent.Required {
+		err := api.saveContact(ussd.PhoneNumber)
+		if err != nil {
+			return err
+		}
+		values["phone"] = ussd.PhoneNumber
+	}
+
+	err := api.sessions.SetAll(ussd.SessionID, values)
+	if err != nil {
+		return err
+	}
+	return api.sessions.Expire(ussd.SessionID, api.sessionTTL)
+}
+
+func (api *ussdAPIServer) deleteUserSession(userID string) error {
+	return api.sessions.Delete(userID)
+}
+
+func (api *ussdAPIServer) responseForSelectService(ussd *ussdPayload, page int) (string, error) {
+	lang, err := api.getUserLanguage(ussd.SessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user language")
+	}
+
+	qc := &questionContext{messages: ussd.content.Messages, page: page}
+	return ussd.content.Questionnaire.render(servicesMenu(ussd.content.Messages, lang), qc, lang, "", false), nil
+}
+
+// servicesMenu is the main menu. It is shown in pages since all the services don't fit on one screen.
+func servicesMenu(messages Messages, lang string) *Question {
+	menu := &Question{
+		ID:       "service",
+		Text:     map[string]string{lang: messages.text("services", lang)},
+		PageSize: servicesPageSize,
+	}
+	for index, service := range messages.list("services_list", lang) {
+		menu.Options = append(menu.Options, &Option{Text: map[string]string{lang: service}, Value: strconv.Itoa(index + 1)})
+	}
+	return menu
+}
+
+// turnServicesPage removes the inputs that page through the services menu so that the service is always the
+// second input of the text. It returns the page of the menu and whether the last input turned the page.
+func turnServicesPage(ussd *ussdPayload) (int, bool) {
+	inputs := strings.Split(ussd.Text, "*")
+	if len(inputs) < 2 {
+		return 0, false
+	}
+
+	var (
+		menu    = servicesMenu(ussd.content.Messages, textLanguage(ussd.Text))
+		qc      = &questionContext{messages: ussd.content.Messages}
+		service = 1
+	)
+	for ; service < len(inputs); service++ {
+		page, ok := menu.turnPage(qc, inputs[service])
+		if !ok {
+			break
+		}
+		qc.page = page
+	}
+
+	ussd.Text = strings.Join(append(inputs[:1], inputs[service:]...), "*")
+	return qc.page, service == len(inputs)
+}
+
+func (api *ussdAPIServer) setUserLanguage(ussd *ussdPayload, language string) error {
+	err := api.sessions.Set(ussd.SessionID, "lang", language)
+	if err != nil {
+		return err
+	}
+	languagesTotal.WithLabelValues(language).Inc()
+	return nil
+}
+
+func (api *ussdAPIServer) getUserLanguage(userID string) (string, error) {
+	return api.sessions.Get(userID, "lang")
+}
+
+func (api *ussdAPIServer) getUserFromSession(sessionID string) (map[string]string, error) {
+	return api.sessions.GetAll(sessionID)
+}
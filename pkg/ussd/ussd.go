@@ -0,0 +1,457 @@
+// Package ussd implements the KoviTrace USSD self-screening service as an http.Handler that receives
+// gateway callbacks. It can be embedded in any Go HTTP server.
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Options contains the dependencies and settings of the USSD handler
+type Options struct {
+	// SQLDB stores contacts, consents and screenings. Required.
+	SQLDB *gorm.DB
+	// PhoneHashKey is the secret used to pseudonymise phone numbers. Required.
+	PhoneHashKey []byte
+	// SessionStore keeps session state. Defaults to an in-memory store.
+	SessionStore SessionStore
+	// SessionTTL is how long a session is kept after it starts. Defaults to 10 minutes.
+	SessionTTL time.Duration
+	// Logger defaults to a logger writing to stdout and stderr
+	Logger grpclog.LoggerV2
+	// RequestLogger receives one structured entry per request. Defaults to a JSON logger writing to stdout.
+	RequestLogger *logrus.Logger
+	// SMSSender sends follow-up messages. Defaults to a sender that only logs.
+	SMSSender SMSSender
+	// Questionnaire is the self-screening questionnaire. Defaults to DefaultQuestionnaire. Ignored when ContentFile is set.
+	Questionnaire *Questionnaire
+	// ContentFile is a yaml file with the questionnaire, screen texts and hotlines. It is checked for changes
+	// every ContentReloadInterval, defaults to 30 seconds.
+	ContentFile           string
+	ContentReloadInterval time.Duration
+	// Hotlines are saved to the hotlines table when it is empty
+	Hotlines []*Hotline
+	// HotlinesReloadInterval is how often hotlines are reloaded from the database. Defaults to 1 minute.
+	HotlinesReloadInterval time.Duration
+	Consent                ConsentOptions
+	// DataRetention is how long identifiable data is kept. Zero disables purging.
+	DataRetention time.Duration
+	// IsolationPeriod is how long users who report a positive test are checked on. Defaults to 14 days.
+	IsolationPeriod time.Duration
+	// FollowUpPhones are sent an SMS when the check-in of a user in isolation or quarantine gets worse
+	FollowUpPhones []string
+	// RateLimiter keeps the token buckets of RateLimits. Defaults to a limiter that keeps them in memory.
+	RateLimiter RateLimiter
+	RateLimits  RateLimitOptions
+	// RequestTimeout is how long a request may take before the user gets a busy screen. It bounds the session,
+	// rate limit and database calls of the request. Defaults to 3 seconds.
+	RequestTimeout time.Duration
+	// Registerer registers the prometheus metrics of the handler. Defaults to prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// NewHandler creates the USSD callback handler. Background jobs stop when the context is cancelled.
+func NewHandler(ctx context.Context, opt *Options) (http.Handler, error) {
+	// Validation
+	switch {
+	case opt == nil:
+		return nil, errors.New("nil options")
+	case opt.SQLDB == nil:
+		return nil, errors.New("nil sql db")
+	case len(opt.PhoneHashKey) == 0:
+		return nil, errors.New("missing phone hash key")
+	}
+
+	api := &ussdAPIServer{
+		sessions:        opt.SessionStore,
+		sessionTTL:      opt.SessionTTL,
+		sqlDB:           opt.SQLDB,
+		logger:          opt.Logger,
+		hotlines:        &hotlineDirectory{},
+		phoneHashKey:    opt.PhoneHashKey,
+		consent:         opt.Consent,
+		sms:             opt.SMSSender,
+		requestLogger:   opt.RequestLogger,
+		contentFile:     opt.ContentFile,
+		isolationPeriod: opt.IsolationPeriod,
+		followUpPhones:  opt.FollowUpPhones,
+		rateLimiter:     opt.RateLimiter,
+		rateLimits:      opt.RateLimits,
+		requestTimeout:  opt.RequestTimeout,
+	}
+
+	// Defaults
+	if api.sessions == nil {
+		api.sessions = NewMemorySessionStore()
+	}
+	if api.sessionTTL == 0 {
+		api.sessionTTL = 10 * time.Minute
+	}
+	if api.logger == nil {
+		api.logger = grpclog.NewLoggerV2(os.Stdout, os.Stderr, os.Stderr)
+	}
+	if api.requestLogger == nil {
+		api.requestLogger = logrus.New()
+		api.requestLogger.SetOutput(os.Stdout)
+		api.requestLogger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	if api.sms == nil {
+		api.sms = &logSMS{logger: api.logger}
+	}
+	api.smsQueue = newSMSQueue(ctx, api.sms, api.logger)
+	if api.rateLimiter == nil {
+		api.rateLimiter = NewMemoryRateLimiter()
+	}
+	if api.requestTimeout == 0 {
+		api.requestTimeout = 3 * time.Second
+	}
+	if api.isolationPeriod == 0 {
+		api.isolationPeriod = 14 * 24 * time.Hour
+	}
+	if api.consent.Required && api.consent.Version == "" {
+		api.consent.Version = "v1"
+	}
+
+	// Auto migration
+	err := AutoMigrate(api.sqlDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to automigrate")
+	}
+
+	registerer := opt.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	err = registerMetrics(registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	registerSQLMetrics(api.sqlDB)
+	registerSQLDeadline(api.sqlDB)
+
+	err = api.seedHotlines(opt.Hotlines)
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.reloadHotlines()
+	if err != nil {
+		return nil, err
+	}
+
+	reloadInterval := opt.HotlinesReloadInterval
+	if reloadInterval == 0 {
+		reloadInterval = time.Minute
+	}
+	go api.runHotlinesReload(ctx, reloadInterval)
+
+	// Content
+	if api.contentFile == "" {
+		questionnaire := opt.Questionnaire
+		if questionnaire == nil {
+			questionnaire = DefaultQuestionnaire()
+		}
+		content := &Content{
+			Version:       builtinContentVersion,
+			Questionnaire: questionnaire,
+			Messages:      DefaultMessages(),
+			Locations:     DefaultLocations(),
+			Articles:      DefaultArticles(),
+		}
+		err = content.Validate()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid content")
+		}
+		api.content = newContentStore(content)
+	} else {
+		err = api.reloadContent()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load content")
+		}
+		contentReloadInterval := opt.ContentReloadInterval
+		if contentReloadInterval == 0 {
+			contentReloadInterval = 30 * time.Second
+		}
+		go api.runContentReload(ctx, contentReloadInterval)
+	}
+
+	go api.runCheckInReminders(ctx, time.Hour)
+
+	// Purge identifiable data after the retention period
+	if opt.DataRetention > 0 {
+		go api.runRetentionJob(ctx, opt.DataRetention, time.Hour)
+	}
+
+	return api, nil
+}
+
+// AutoMigrate creates or updates the tables used by the service
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&contact{}, &screening{}, &screeningAnswer{}, &consentRecord{}, &Hotline{}, &countyCases{}, &Facility{}, &testResult{}, &checkIn{},
+		&exposure{}, &healthWorker{}, &contentHotlines{},
+	).Error
+}
+
+type ussdPayload struct {
+	SessionID   string `json:"sessionId,omitempty"`
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+	NetworkCode string `json:"networkCode,omitempty"`
+	ServiceCode string `json:"serviceCode,omitempty"`
+	Text        string `json:"text,omitempty"`
+	// rawText is the text sent by the gateway, Text has the consent input removed
+	rawText string
+	// content is the content version of the session
+	content *Content
+}
+
+type ussdAPIServer struct {
+	sessions      SessionStore
+	sessionTTL    time.Duration
+	sqlDB         *gorm.DB
+	logger        grpclog.LoggerV2
+	hotlines      *hotlineDirectory
+	phoneHashKey  []byte
+	consent       ConsentOptions
+	sms           SMSSender
+	smsQueue      *smsQueue
+	requestLogger *logrus.Logger
+	content       *contentStore
+	contentFile   string
+	// isolationPeriod is counted from the test date of positive results
+	isolationPeriod time.Duration
+	followUpPhones  []string
+	rateLimiter     RateLimiter
+	rateLimits      RateLimitOptions
+	requestTimeout  time.Duration
+}
+
+func (api *ussdAPIServer) httpError(w *requestLog, userID, errMsg string, err error, statusCode int) {
+	w.errMsg = errMsg
+	w.err = err
+	api.deleteUserSession(userID)
+	http.Error(w, "END "+errMsg, statusCode)
+}
+
+func (api *ussdAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl := &requestLog{ResponseWriter: w, start: time.Now(), status: http.StatusOK, timings: &requestTimings{}}
+
+	if r.Method != http.MethodPost {
+		http.Error(rl, "only POST method allowed", http.StatusInternalServerError)
+		api.logRequest(rl)
+		return
+	}
+
+	// The body is read before the deadline starts since it can't be read once the handler has returned
+	err := r.ParseForm()
+	if err != nil {
+		rl.err = err
+		http.Error(rl, fmt.Sprintf("failed to parse form: %v", err), http.StatusInternalServerError)
+		api.logRequest(rl)
+		return
+	}
+
+	api.serveWithDeadline(w, r, rl)
+}
+
+func (api *ussdAPIServer) serveUSSD(w *requestLog, r *http.Request) {
+	var err error
+
+	ussd := &ussdPayload{
+		SessionID:   r.FormValue("sessionId"),
+		PhoneNumber: r.FormValue("phoneNumber"),
+		NetworkCode: r.FormValue("networkCode"),
+		ServiceCode: r.FormValue("serviceCode"),
+		Text:        r.FormValue("text"),
+		rawText:     r.FormValue("text"),
+	}
+	w.ussd = ussd
+
+	// Limits are checked before the session is read so that floods don't reach redis and the database
+	if !api.allowRequest(ussd) {
+		w.stage = "rate_limited"
+		w.Write([]byte("END " + api.content.current().Messages.text("rate_limited", textLanguage(ussd.Text))))
+		return
+	}
+
+	ussd.content, err = api.sessionContent(ussd)
+	if err != nil {
+		api.httpError(w, ussd.SessionID, "failed to get session", err, http.StatusInternalServerError)
+		return
+	}
+
+	var response string
+
+	// Consent screen comes right after language selection
+	if api.consent.Required {
+		switch strings.Count(ussd.Text, "*") {
+		case 0:
+		case 1:
+			w.stage = "consent"
+			response, err = api.handleConsent(r.Context(), ussd)
+			if err != nil {
+				api.httpError(w, ussd.SessionID, "failed to save consent", err, http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(response))
+			return
+		default:
+			ussd.Text = stripConsent(ussd.Text)
+		}
+	}
+
+	var (
+		servicesPage, paging = turnServicesPage(ussd)
+		lang                 = textLanguage(ussd.Text)
+	)
+
+	switch {
+	case paging:
+		w.stage = "services_page"
+		response, err = api.responseForSelectService(ussd, servicesPage)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to create response for services", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "":
+		w.stage = "start"
+		sessionsTotal.WithLabelValues(ussd.NetworkCode).Inc()
+
+		// Save user
+		err = api.saveUser(ussd)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to save user", err, http.StatusInternalServerError)
+			return
+		}
+
+		response = "CON " + ussd.content.Messages.text("welcome", eng)
+
+	case ussd.Text == "1" || ussd.Text == "2":
+		w.stage = "language"
+		err = api.setUserLanguage(ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to set user language", err, http.StatusInternalServerError)
+			return
+		}
+		if api.consent.Required {
+			response = api.responseForConsent(ussd, lang)
+			break
+		}
+		response, err = api.responseForSelectService(ussd, 0)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to create response for services", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*2" || ussd.Text == "2*2":
+		w.stage = "hotlines_county"
+		response = "CON " + ussd.content.Messages.text("hotlines_county", lang)
+
+	case strings.HasPrefix(ussd.Text, "1*2*") || strings.HasPrefix(ussd.Text, "2*2*"):
+		w.stage = "hotlines"
+		// The county is asked again until it is valid
+		county := ussd.Text[strings.LastIndex(ussd.Text, "*")+1:]
+		ans, invalid := countyQuestion.parse(&questionContext{}, county)
+		if invalid != nil {
+			w.stage = "hotlines_county_invalid"
+			response = "CON " + invalid.text(ussd.content.Messages, lang) + "\n" + ussd.content.Messages.text("hotlines_county", lang)
+			break
+		}
+		response = api.responseForHotlines(ussd, ans.values[0], lang)
+
+	case ussd.Text == "1*3" || ussd.Text == "2*3" ||
+		strings.HasPrefix(ussd.Text, "1*3*") || strings.HasPrefix(ussd.Text, "2*3*"):
+		w.stage = "county_stats"
+		response, err = api.handleCountyStats(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to get county stats", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*4" || ussd.Text == "2*4" ||
+		strings.HasPrefix(ussd.Text, "1*4*") || strings.HasPrefix(ussd.Text, "2*4*"):
+		w.stage = "articles"
+		response, err = api.handleArticles(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to get articles", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*5" || ussd.Text == "2*5" ||
+		strings.HasPrefix(ussd.Text, "1*5*") || strings.HasPrefix(ussd.Text, "2*5*"):
+		w.stage = "facilities_county"
+		response, err = api.handleFacilities(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to get facilities", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*6" || ussd.Text == "2*6" ||
+		strings.HasPrefix(ussd.Text, "1*6*") || strings.HasPrefix(ussd.Text, "2*6*"):
+		w.stage = "result"
+		response, err = api.handleTestResult(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to save test result", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*7" || ussd.Text == "2*7" ||
+		strings.HasPrefix(ussd.Text, "1*7*") || strings.HasPrefix(ussd.Text, "2*7*"):
+		w.stage = "exposure"
+		response, err = api.handleExposures(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to notify contacts", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*8" || ussd.Text == "2*8" ||
+		strings.HasPrefix(ussd.Text, "1*8*") || strings.HasPrefix(ussd.Text, "2*8*"):
+		w.stage = "worker"
+		response, err = api.handleHealthWorker(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to process health worker request", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*9" || ussd.Text == "2*9" ||
+		strings.HasPrefix(ussd.Text, "1*9*") || strings.HasPrefix(ussd.Text, "2*9*"):
+		w.stage = "household"
+		response, err = api.handleHousehold(r.Context(), w, ussd, lang)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to process household screening", err, http.StatusInternalServerError)
+			return
+		}
+
+	case ussd.Text == "1*1" || ussd.Text == "2*1" ||
+		strings.HasPrefix(ussd.Text, "1*1*") || strings.HasPrefix(ussd.Text, "2*1*"):
+		w.stage = "screening"
+		response, err = api.handleScreening(r.Context(), w, ussd)
+		if err != nil {
+			api.httpError(w, ussd.SessionID, "failed to process screening", err, http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		w.stage = "unknown"
+
+	}
+	if err != nil {
+		api.httpError(w, ussd.SessionID, "failed to process request", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Send response
+	w.Write([]byte(response))
+}